@@ -0,0 +1,255 @@
+// yaya-sim 在没有先驱与QQ账号的环境下模拟运行 OneBot-YaYa
+// 读取 config.yml 启动全部 OneBot 服务，再按脚本向 XQEvent 注入事件，
+// 机器人对框架的每一次调用都会以 JSON 行的形式记录下来
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+
+	"yaya/onebot"
+)
+
+// Script 模拟脚本，world 描述假框架里的账号、好友与群，events 按顺序注入
+type Script struct {
+	World  *onebot.MemoryBackend `yaml:"world" json:"world"`
+	Events []ScriptEvent         `yaml:"events" json:"events"`
+}
+
+// ScriptEvent 一条模拟事件，字段使用 OneBot 的含义，由 toXQ 转换为先驱的参数
+type ScriptEvent struct {
+	Delay      int64  `yaml:"delay" json:"delay"` // 距上一条事件的毫秒数
+	Type       string `yaml:"type" json:"type"`
+	XQType     int64  `yaml:"xq_type" json:"xq_type"` // type 为 raw 时直接使用的先驱事件类型
+	SubType    int64  `yaml:"sub_type" json:"sub_type"`
+	SelfID     int64  `yaml:"self_id" json:"self_id"`
+	GroupID    int64  `yaml:"group_id" json:"group_id"`
+	UserID     int64  `yaml:"user_id" json:"user_id"`
+	OperatorID int64  `yaml:"operator_id" json:"operator_id"`
+	Message    string `yaml:"message" json:"message"`
+	MessageNum int64  `yaml:"message_num" json:"message_num"`
+	MessageID  int64  `yaml:"message_id" json:"message_id"`
+	Flag       string `yaml:"flag" json:"flag"`
+}
+
+// xqEvent XQEvent 的参数
+type xqEvent struct {
+	selfID, type_, subType, groupID, userID, noticeID int64
+	message                                           string
+	messageNum, messageID                             int64
+	rawMessage                                        string
+	time, ret                                         int64
+}
+
+var messageNum int64 = 0
+
+func main() {
+	config := flag.String("config", onebot.AppPath+"config.yml", "OneBot-YaYa 配置文件")
+	script := flag.String("script", "", "YAML/JSON 格式的模拟脚本")
+	record := flag.String("record", "", "记录框架调用的文件，默认输出到标准输出")
+	exit := flag.Bool("exit", false, "脚本执行完毕后退出")
+	actions := flag.Bool("actions", false, "以 Markdown 表格输出支持的 action 后退出")
+	flag.Parse()
+
+	if *actions {
+		printActions(os.Stdout)
+		return
+	}
+
+	s := &Script{World: onebot.NewMemoryBackend()}
+	if *script != "" {
+		data, err := ioutil.ReadFile(*script)
+		if err != nil {
+			fatal("读取脚本失败: %v", err)
+		}
+		if err := yaml.Unmarshal(data, s); err != nil {
+			fatal("解析脚本失败: %v", err)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *record != "" {
+		f, err := os.OpenFile(*record, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+		if err != nil {
+			fatal("打开记录文件失败: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+
+	backend := s.World
+	backend.Log = os.Stderr
+	backend.OnCall = func(call onebot.BackendCall) {
+		mu.Lock()
+		enc.Encode(call)
+		mu.Unlock()
+		// 和先驱一样在发送成功后回传一条回音消息，插件会忽略它，message_id 来自 SendMsgEX_V2 的返回值
+		if call.Method == "SendMsgEX_V2" {
+			ret := gjson.Parse(call.Return.(string))
+			go onebot.XQEvent(
+				call.Args["self_id"].(int64),
+				10,
+				0,
+				call.Args["group_id"].(int64),
+				call.Args["user_id"].(int64),
+				0,
+				call.Args["message"].(string),
+				ret.Get("msgno").Int(),
+				ret.Get("msgid").Int(),
+				"",
+				time.Now().Unix(),
+				0,
+			)
+		}
+	}
+	onebot.XQ = backend
+
+	if !onebot.Start(*config) {
+		os.Exit(1)
+	}
+
+	for _, e := range s.Events {
+		time.Sleep(time.Millisecond * time.Duration(e.Delay))
+		x, ok := e.toXQ(backend)
+		if !ok {
+			onebot.WARN("[模拟] 未知的事件类型 %v", e.Type)
+			continue
+		}
+		onebot.XQEvent(x.selfID, x.type_, x.subType, x.groupID, x.userID, x.noticeID,
+			x.message, x.messageNum, x.messageID, x.rawMessage, x.time, x.ret)
+	}
+	onebot.INFO("[模拟] 脚本执行完毕，共 %v 条事件", len(s.Events))
+
+	if *exit {
+		// 给异步的事件推送留一点时间
+		time.Sleep(time.Second * 1)
+		return
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+}
+
+// toXQ 把脚本事件转换为先驱的事件参数
+func (e ScriptEvent) toXQ(backend *onebot.MemoryBackend) (xqEvent, bool) {
+	x := xqEvent{
+		selfID:     e.SelfID,
+		subType:    e.SubType,
+		groupID:    e.GroupID,
+		userID:     e.UserID,
+		message:    e.Message,
+		messageNum: e.MessageNum,
+		messageID:  e.MessageID,
+		time:       time.Now().Unix(),
+	}
+	if x.selfID == 0 && len(backend.Bots) > 0 {
+		x.selfID = backend.Bots[0]
+	}
+	// 先驱的通知事件中 userID 是操作者，noticeID 才是被操作的对象
+	notice := func(type_ int64) {
+		x.type_ = type_
+		x.userID = e.OperatorID
+		x.noticeID = e.UserID
+	}
+	switch strings.ToLower(e.Type) {
+	case "raw":
+		x.type_ = e.XQType
+	case "private", "private_message":
+		x.type_ = 1
+		x.withMessageNum()
+	case "group", "group_message":
+		x.type_ = 2
+		x.withMessageNum()
+	case "group_upload":
+		x.type_ = 218
+	case "admin_set":
+		x.type_ = 210
+	case "admin_unset":
+		x.type_ = 211
+	case "group_leave":
+		notice(201)
+	case "group_kick":
+		notice(202)
+	case "group_increase", "group_join":
+		notice(212)
+	case "group_ban":
+		notice(203)
+	case "group_lift_ban":
+		notice(204)
+	case "friend_add":
+		x.type_ = 102
+	case "group_recall":
+		notice(9)
+		x.subType = 2
+	case "friend_recall":
+		notice(9)
+		x.subType = 1
+	case "friend_request":
+		x.type_ = 101
+		x.noticeID = e.UserID
+	case "group_request":
+		x.type_ = 213
+		x.noticeID = e.UserID
+		x.rawMessage = e.Flag
+	case "group_invite":
+		notice(214)
+		x.rawMessage = e.Flag
+	default:
+		return x, false
+	}
+	return x, true
+}
+
+// withMessageNum 没有指定消息序号时自动生成
+func (x *xqEvent) withMessageNum() {
+	if x.messageNum == 0 {
+		messageNum++
+		x.messageNum = messageNum
+	}
+	if x.messageID == 0 {
+		x.messageID = 2000000 + x.messageNum
+	}
+}
+
+// printActions 根据注册表生成 docs/Actions.md
+func printActions(w io.Writer) {
+	fmt.Fprintln(w, "# Actions")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "由 `go run ./cmd/yaya-sim -actions` 生成，参数后带 `*` 的为必填")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "| action | 说明 | 参数 | 先驱支持 |")
+	fmt.Fprintln(w, "| ------ | ---- | ---- | -------- |")
+	for _, a := range onebot.Actions() {
+		params := []string{}
+		for _, p := range a.Params {
+			param := fmt.Sprintf("`%s` %s", p.Name, p.Type)
+			if p.Required {
+				param += " *"
+			}
+			params = append(params, param)
+		}
+		supported := "✔"
+		if !a.Supported {
+			supported = "✘"
+		}
+		fmt.Fprintf(w, "| `%s` | %s | %s | %s |\n", a.Name, a.Description, strings.Join(params, "<br>"), supported)
+	}
+}
+
+func fatal(s string, v ...interface{}) {
+	onebot.ERROR(s, v...)
+	os.Exit(1)
+}
@@ -5,7 +5,6 @@ go 1.14
 require (
 	github.com/Yiwen-Chan/go-silk v0.0.5
 	github.com/gorilla/websocket v1.4.2
-	github.com/hjson/hjson-go v3.1.0+incompatible
 	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/tidwall/gjson v1.6.3
 	golang.org/x/text v0.3.4
@@ -0,0 +1,331 @@
+package onebot
+
+func init() {
+	apiMap.Register(actions...)
+}
+
+// Actions 已注册的全部 action，按名字排序
+func Actions() []*Action {
+	return apiMap.List()
+}
+
+// actions 对外提供的全部 OneBot action，新增 action 需要在这里登记
+var actions = []Action{
+	// 消息
+	{
+		Name:        "send_private_msg",
+		Description: "发送私聊消息",
+		Params:      []Param{{"user_id", "int64", true}, {"message", "message", true}, {"auto_escape", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).SendPrivateMsg,
+	},
+	{
+		Name:        "send_group_msg",
+		Description: "发送群消息",
+		Params:      []Param{{"group_id", "int64", true}, {"message", "message", true}, {"auto_escape", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).SendGroupMsg,
+	},
+	{
+		Name:        "send_msg",
+		Description: "发送消息",
+		Params:      []Param{{"message_type", "string", false}, {"user_id", "int64", false}, {"group_id", "int64", false}, {"message", "message", true}, {"auto_escape", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).SendMsg,
+	},
+	{
+		Name:        "send_group_forward_msg",
+		Description: "发送群合并转发消息，forward 为 xml 时只发送无法点开的预览卡片，仅支持不超过 4 条纯文本，没有 message_id",
+		Params:      []Param{{"group_id", "int64", true}, {"messages", "message", true}},
+		Supported:   true,
+		Handler:     (*Routers).SendGroupForwardMsg,
+	},
+	{
+		Name:        "send_private_forward_msg",
+		Description: "发送私聊合并转发消息，forward 为 xml 时只发送无法点开的预览卡片，仅支持不超过 4 条纯文本，没有 message_id",
+		Params:      []Param{{"user_id", "int64", true}, {"messages", "message", true}},
+		Supported:   true,
+		Handler:     (*Routers).SendPrivateForwardMsg,
+	},
+	{
+		Name:        "delete_msg",
+		Description: "撤回消息",
+		Params:      []Param{{"message_id", "int64", true}},
+		Supported:   true,
+		Handler:     (*Routers).DeleteMsg,
+	},
+	{
+		Name:        "get_msg",
+		Description: "获取消息",
+		Params:      []Param{{"message_id", "int64", true}},
+		Supported:   true,
+		Handler:     (*Routers).GetMsg,
+	},
+	{
+		Name:        "get_forward_msg",
+		Description: "获取合并转发消息",
+		Params:      []Param{{"id", "string", true}},
+		Supported:   false,
+		Handler:     (*Routers).GetForwardMsg,
+	},
+	{
+		Name:        "send_like",
+		Description: "发送好友赞",
+		Params:      []Param{{"user_id", "int64", true}, {"times", "int64", false}},
+		Supported:   true,
+		Handler:     (*Routers).SendLike,
+	},
+	// 群管理
+	{
+		Name:        "set_group_kick",
+		Description: "群组踢人",
+		Params:      []Param{{"group_id", "int64", true}, {"user_id", "int64", true}, {"reject_add_request", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetGroupKick,
+	},
+	{
+		Name:        "set_group_ban",
+		Description: "群组单人禁言",
+		Params:      []Param{{"group_id", "int64", true}, {"user_id", "int64", true}, {"duration", "int64", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetGroupBan,
+	},
+	{
+		Name:        "set_group_anonymous_ban",
+		Description: "群组匿名用户禁言",
+		Params:      []Param{{"group_id", "int64", true}, {"anonymous", "object", false}, {"anonymous_flag", "string", false}, {"flag", "string", false}, {"duration", "int64", false}},
+		Supported:   false,
+		Handler:     (*Routers).SetGroupAnonymousBan,
+	},
+	{
+		Name:        "set_group_whole_ban",
+		Description: "群组全员禁言",
+		Params:      []Param{{"group_id", "int64", true}, {"enable", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetGroupWholeBan,
+	},
+	{
+		Name:        "set_group_admin",
+		Description: "群组设置管理员",
+		Params:      []Param{{"group_id", "int64", true}, {"user_id", "int64", true}, {"enable", "bool", false}},
+		Supported:   false,
+		Handler:     (*Routers).SetGroupAdmin,
+	},
+	{
+		Name:        "set_group_anonymous",
+		Description: "群组匿名",
+		Params:      []Param{{"group_id", "int64", true}, {"enable", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetGroupAnonymous,
+	},
+	{
+		Name:        "set_group_card",
+		Description: "设置群名片",
+		Params:      []Param{{"group_id", "int64", true}, {"user_id", "int64", true}, {"card", "string", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetGroupCard,
+	},
+	{
+		Name:        "set_group_name",
+		Description: "设置群名",
+		Params:      []Param{{"group_id", "int64", true}, {"group_name", "string", true}},
+		Supported:   false,
+		Handler:     (*Routers).SetGroupName,
+	},
+	{
+		Name:        "set_group_leave",
+		Description: "退出群组",
+		Params:      []Param{{"group_id", "int64", true}, {"is_dismiss", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetGroupLeave,
+	},
+	{
+		Name:        "set_group_special_title",
+		Description: "设置群组专属头衔",
+		Params:      []Param{{"group_id", "int64", true}, {"user_id", "int64", true}, {"special_title", "string", false}, {"duration", "int64", false}},
+		Supported:   false,
+		Handler:     (*Routers).SetGroupSpecialTitle,
+	},
+	// 请求
+	{
+		Name:        "set_friend_add_request",
+		Description: "处理加好友请求",
+		Params:      []Param{{"flag", "string", true}, {"approve", "bool", false}, {"remark", "string", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetFriendAddRequest,
+	},
+	{
+		Name:        "set_group_add_request",
+		Description: "处理加群请求或邀请",
+		Params:      []Param{{"flag", "string", true}, {"sub_type", "string", false}, {"type", "string", false}, {"approve", "bool", false}, {"reason", "string", false}},
+		Supported:   true,
+		Handler:     (*Routers).SetGroupAddRequest,
+	},
+	// 信息
+	{
+		Name:        "get_login_info",
+		Description: "获取登录号信息",
+		Supported:   true,
+		Handler:     (*Routers).GetLoginInfo,
+	},
+	{
+		Name:        "get_stranger_info",
+		Description: "获取陌生人信息",
+		Params:      []Param{{"user_id", "int64", true}, {"no_cache", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetStrangerInfo,
+	},
+	{
+		Name:        "get_friend_list",
+		Description: "获取好友列表",
+		Supported:   true,
+		Handler:     (*Routers).GetFriendList,
+	},
+	{
+		Name:        "get_group_info",
+		Description: "获取群信息",
+		Params:      []Param{{"group_id", "int64", true}, {"no_cache", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetGroupInfo,
+	},
+	{
+		Name:        "get_group_list",
+		Description: "获取群列表",
+		Supported:   true,
+		Handler:     (*Routers).GetGroupList,
+	},
+	{
+		Name:        "get_group_member_info",
+		Description: "获取群成员信息",
+		Params:      []Param{{"group_id", "int64", true}, {"user_id", "int64", true}, {"no_cache", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetGroupMemberInfo,
+	},
+	{
+		Name:        "get_group_member_list",
+		Description: "获取群成员列表",
+		Params:      []Param{{"group_id", "int64", true}, {"no_cache", "bool", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetGroupMemberList,
+	},
+	{
+		Name:        "get_group_honor_info",
+		Description: "获取群荣誉信息",
+		Params:      []Param{{"group_id", "int64", true}, {"type", "string", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetGroupHonorInfo,
+	},
+	{
+		Name:        "get_cookies",
+		Description: "获取 Cookies",
+		Params:      []Param{{"domain", "string", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetCookies,
+	},
+	{
+		Name:        "get_csrf_token",
+		Description: "获取 CSRF Token",
+		Supported:   false,
+		Handler:     (*Routers).GetCsrfToken,
+	},
+	{
+		Name:        "get_credentials",
+		Description: "获取 QQ 相关接口凭证",
+		Params:      []Param{{"domain", "string", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetCredentials,
+	},
+	{
+		Name:        "get_record",
+		Description: "获取语音",
+		Params:      []Param{{"file", "string", true}, {"out_format", "string", true}},
+		Supported:   false,
+		Handler:     (*Routers).GetRecord,
+	},
+	{
+		Name:        "get_image",
+		Description: "获取图片",
+		Params:      []Param{{"file", "string", true}},
+		Supported:   false,
+		Handler:     (*Routers).GetImage,
+	},
+	{
+		Name:        "can_send_image",
+		Description: "检查是否可以发送图片",
+		Supported:   true,
+		Handler:     (*Routers).CanSendImage,
+	},
+	{
+		Name:        "can_send_record",
+		Description: "检查是否可以发送语音",
+		Supported:   true,
+		Handler:     (*Routers).CanSendRecord,
+	},
+	{
+		Name:        "get_status",
+		Description: "获取运行状态",
+		Supported:   true,
+		Handler:     (*Routers).GetStatus,
+	},
+	{
+		Name:        "get_supported_actions",
+		Description: "获取支持的 action、消息段与事件",
+		Supported:   true,
+		Handler:     (*Routers).GetSupportedActions,
+	},
+	{
+		Name:        "get_version_info",
+		Description: "获取版本信息",
+		Supported:   true,
+		Handler:     (*Routers).GetVersionInfo,
+	},
+	{
+		Name:        "set_restart",
+		Description: "重启 OneBot 实现",
+		Params:      []Param{{"delay", "int64", false}},
+		Supported:   false,
+		Handler:     (*Routers).SetRestart,
+	},
+	{
+		Name:        "clean_cache",
+		Description: "清理缓存",
+		Supported:   false,
+		Handler:     (*Routers).CleanCache,
+	},
+	{
+		Name:        ".handle_quick_operation",
+		Description: "对事件执行快速操作",
+		Params:      []Param{{"context", "object", true}, {"operation", "object", true}},
+		Supported:   true,
+		Handler:     (*Routers).HandleQuickOperation,
+	},
+	// 先驱扩展
+	{
+		Name:        "out_put_log",
+		Description: "输出日志到先驱框架",
+		Params:      []Param{{"text", "string", true}},
+		Supported:   true,
+		Handler:     (*Routers).OutPutLog,
+	},
+	{
+		Name:        "send_xml",
+		Description: "发送 XML 卡片消息",
+		Params:      []Param{{"message_type", "string", false}, {"group_id", "int64", false}, {"user_id", "int64", false}, {"data", "string", true}},
+		Supported:   true,
+		Handler:     (*Routers).SendXml,
+	},
+	{
+		Name:        "send_json",
+		Description: "发送 JSON 卡片消息",
+		Params:      []Param{{"message_type", "string", false}, {"group_id", "int64", false}, {"user_id", "int64", false}, {"data", "string", true}},
+		Supported:   true,
+		Handler:     (*Routers).SendJson,
+	},
+	{
+		Name:        "get_group_activity",
+		Description: "获取群成员发言统计",
+		Params:      []Param{{"group_id", "int64", true}, {"days", "int64", false}, {"limit", "int64", false}},
+		Supported:   true,
+		Handler:     (*Routers).GetGroupActivity,
+	},
+}
@@ -0,0 +1,182 @@
+package onebot
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// get_group_activity 的默认统计天数与活跃排行人数
+const (
+	defaultActivityDays  = 30
+	defaultActivityLimit = 10
+)
+
+// XMemberStat 群成员的发言统计
+type XMemberStat struct {
+	GroupID       int64 `db:"group_id" json:"group_id"`
+	UserID        int64 `db:"user_id" json:"user_id"`
+	MessageCount  int64 `db:"message_count" json:"message_count"`
+	FirstSentTime int64 `db:"first_sent_time" json:"first_sent_time"`
+	LastSentTime  int64 `db:"last_sent_time" json:"last_sent_time"`
+}
+
+// XMemberDaily 群成员每天的发言条数，date 为 20060102 格式
+type XMemberDaily struct {
+	GroupID      int64 `db:"group_id" json:"group_id"`
+	UserID       int64 `db:"user_id" json:"user_id"`
+	Date         int64 `db:"date" json:"date"`
+	MessageCount int64 `db:"message_count" json:"message_count"`
+}
+
+func activityDate(t time.Time) int64 {
+	return Str2Int(t.Format("20060102"))
+}
+
+// recordActivity 记录一条群消息的发言统计，两张表在一个事务中写入
+// 写入成功后才更新内存中的统计，dbLock 保证同一时间只有一条消息在计数
+func (c *botCache) recordActivity(xe XEvent) {
+	if c == nil {
+		return
+	}
+	c.dbLock.Lock()
+	defer c.dbLock.Unlock()
+	c.lock.Lock()
+	stat := c.stats[xe.GroupID][xe.UserID]
+	c.lock.Unlock()
+	if stat.MessageCount == 0 {
+		stat = XMemberStat{GroupID: xe.GroupID, UserID: xe.UserID, FirstSentTime: xe.Time}
+	}
+	stat.MessageCount++
+	stat.LastSentTime = xe.Time
+
+	if c.bot.DB != nil {
+		date := activityDate(time.Unix(xe.Time, 0))
+		err := c.bot.dbTx(func(tx *sql.Tx) {
+			if txExec(tx,
+				"UPDATE XMemberStat SET message_count=message_count+1, last_sent_time=? WHERE group_id=? AND user_id=?",
+				xe.Time, xe.GroupID, xe.UserID,
+			) == 0 {
+				txInsert(tx, &stat)
+			}
+			if txExec(tx,
+				"UPDATE XMemberDaily SET message_count=message_count+1 WHERE group_id=? AND user_id=? AND date=?",
+				xe.GroupID, xe.UserID, date,
+			) == 0 {
+				txInsert(tx, &XMemberDaily{GroupID: xe.GroupID, UserID: xe.UserID, Date: date, MessageCount: 1})
+			}
+		})
+		if err != nil {
+			ERROR("[统计][%v] 群 %v 成员 %v 的发言统计写入失败: %v", c.bot.Bot, xe.GroupID, xe.UserID, err)
+			return
+		}
+	}
+
+	c.lock.Lock()
+	if c.stats[xe.GroupID] == nil {
+		c.stats[xe.GroupID] = map[int64]XMemberStat{}
+	}
+	c.stats[xe.GroupID][xe.UserID] = stat
+	c.lock.Unlock()
+}
+
+// withActivity 用发言统计更新群成员的 last_sent_time，调用时需持有锁
+func (c *botCache) withActivity(m XGroupMember) XGroupMember {
+	if stat, ok := c.stats[m.GroupID][m.UserID]; ok && stat.LastSentTime > m.LastSentTime {
+		m.LastSentTime = stat.LastSentTime
+	}
+	return m
+}
+
+// stat 群成员的发言统计
+func (c *botCache) stat(groupID int64, userID int64) XMemberStat {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if stat, ok := c.stats[groupID][userID]; ok {
+		return stat
+	}
+	return XMemberStat{GroupID: groupID, UserID: userID}
+}
+
+// GetGroupActivity YaYa特有，最近 days 天的发言排行与 days 天内没有发言的群成员
+func (this *Routers) GetGroupActivity(bot *BotYaml, params gjson.Result) Result {
+	var groupID int64 = params.Get("group_id").Int()
+	if groupID == 0 {
+		return makeError(ErrBadRequest, "invalid 'group_id'")
+	}
+	var days int64 = defaultActivityDays
+	if params.Get("days").Exists() {
+		days = params.Get("days").Int()
+	}
+	var limit int64 = defaultActivityLimit
+	if params.Get("limit").Exists() {
+		limit = params.Get("limit").Int()
+	}
+	if days <= 0 {
+		return makeError(ErrBadRequest, "invalid 'days'")
+	}
+	members, ok := bot.Cache.memberList(groupID, false)
+	if !ok {
+		return makeError(ErrBackendFailure, "failed to get group member list")
+	}
+	since := time.Now().AddDate(0, 0, int(1-days))
+
+	// 发言排行
+	counts := map[int64]int64{}
+	if bot.DB != nil {
+		var daily []XMemberDaily
+		bot.dbSelectList(&daily, fmt.Sprintf("group_id=%d and date>=%d", groupID, activityDate(since)))
+		for _, d := range daily {
+			counts[d.UserID] += d.MessageCount
+		}
+	}
+	top := []map[string]interface{}{}
+	for _, m := range members {
+		if counts[m.UserID] == 0 {
+			continue
+		}
+		stat := bot.Cache.stat(groupID, m.UserID)
+		top = append(top, map[string]interface{}{
+			"user_id":         m.UserID,
+			"nickname":        m.Nickname,
+			"card":            m.Card,
+			"message_count":   counts[m.UserID],
+			"first_sent_time": stat.FirstSentTime,
+			"last_sent_time":  m.LastSentTime,
+		})
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i]["message_count"].(int64) > top[j]["message_count"].(int64)
+	})
+	if limit >= 0 && int64(len(top)) > limit {
+		top = top[:limit]
+	}
+
+	// 入群与最后发言都在 days 天之前的群成员，最久没有发言的在前
+	cutoff := time.Now().Add(-time.Duration(days) * 24 * time.Hour).Unix()
+	inactive := []map[string]interface{}{}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].LastSentTime < members[j].LastSentTime })
+	for _, m := range members {
+		if m.UserID == bot.Bot || m.LastSentTime >= cutoff || m.JoinTime >= cutoff {
+			continue
+		}
+		inactive = append(inactive, map[string]interface{}{
+			"user_id":        m.UserID,
+			"nickname":       m.Nickname,
+			"card":           m.Card,
+			"role":           m.Role,
+			"join_time":      m.JoinTime,
+			"last_sent_time": m.LastSentTime,
+			"message_count":  bot.Cache.stat(groupID, m.UserID).MessageCount,
+		})
+	}
+	return makeOk(map[string]interface{}{
+		"group_id":    groupID,
+		"days":        days,
+		"top_talkers": top,
+		"inactive":    inactive,
+	})
+}
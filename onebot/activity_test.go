@@ -0,0 +1,75 @@
+package onebot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupActivity(t *testing.T) {
+	backend := testBackend()
+	joined := time.Now().AddDate(0, 0, -100).Unix()
+	for _, m := range backend.Groups[testGroup].Members {
+		m.JoinTime = joined
+	}
+	bot := testBotYaml(testBot)
+	useBackend(t, backend)
+	useConf(t, bot)
+	openTestDB(t, bot)
+
+	// 路人今天发言 3 条，主人只在 40 天前发言 1 条
+	now := time.Now().Unix()
+	old := time.Now().AddDate(0, 0, -40).Unix()
+	for i := int64(0); i < 3; i++ {
+		bot.Cache.recordActivity(XEvent{GroupID: testGroup, UserID: testUser, Time: now - 2 + i})
+	}
+	bot.Cache.recordActivity(XEvent{GroupID: testGroup, UserID: testOwner, Time: old})
+
+	tests := []struct {
+		params   string
+		top      string
+		inactive string
+	}{
+		{`{"group_id":30001}`, "20002:3", "20001"},
+		{`{"group_id":30001,"days":60}`, "20002:3,20001:1", ""},
+		{`{"group_id":30001,"days":60,"limit":1}`, "20002:3", ""},
+		{`{"group_id":30001,"days":60,"limit":0}`, "", ""},
+		{`{"group_id":30001,"days":200}`, "20002:3,20001:1", ""},
+	}
+	for _, tt := range tests {
+		ret := callApi(t, "get_group_activity", tt.params)
+		if ret.Get("status").String() != "ok" {
+			t.Fatalf("%v: %v", tt.params, ret.Raw)
+		}
+		top := ""
+		for _, m := range ret.Get("data.top_talkers").Array() {
+			if top != "" {
+				top += ","
+			}
+			top += m.Get("user_id").String() + ":" + m.Get("message_count").String()
+		}
+		if top != tt.top {
+			t.Errorf("%v: top_talkers = %v, want %v", tt.params, top, tt.top)
+		}
+		inactive := ""
+		for _, m := range ret.Get("data.inactive").Array() {
+			if inactive != "" {
+				inactive += ","
+			}
+			inactive += m.Get("user_id").String()
+		}
+		if inactive != tt.inactive {
+			t.Errorf("%v: inactive = %v, want %v", tt.params, inactive, tt.inactive)
+		}
+	}
+
+	ret := callApi(t, "get_group_activity", `{"group_id":30001}`)
+	if got := ret.Get("data.top_talkers.0.first_sent_time").Int(); got != now-2 {
+		t.Errorf("first_sent_time = %v, want %v", got, now-2)
+	}
+	if got := ret.Get("data.inactive.0.last_sent_time").Int(); got != old {
+		t.Errorf("inactive last_sent_time = %v, want %v", got, old)
+	}
+	if ret := callApi(t, "get_group_activity", `{"group_id":30001,"days":0}`); ret.Get("retcode").Int() != ErrBadRequest.Retcode {
+		t.Errorf("days 0: %v", ret.Raw)
+	}
+}
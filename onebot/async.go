@@ -0,0 +1,91 @@
+package onebot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// asyncWorkers 执行 _async 调用的协程数
+const asyncWorkers = 8
+
+// rateLimitInterval 相邻两次 _rate_limited 调用之间的最小间隔
+const rateLimitInterval = time.Millisecond * 500
+
+// apiJob 排队等待执行的调用
+type apiJob struct {
+	action string
+	bot    int64
+	params gjson.Result
+}
+
+var (
+	asyncJobs       = make(chan apiJob, 1000)
+	rateLimitedJobs = make(chan apiJob, 1000)
+)
+
+func init() {
+	for i := 0; i < asyncWorkers; i++ {
+		go runJobs(asyncJobs, 0)
+	}
+	go runJobs(rateLimitedJobs, rateLimitInterval)
+}
+
+// actionName 去掉 _async 与 _rate_limited 后缀
+func actionName(action string) string {
+	action = strings.TrimSuffix(action, "_async")
+	return strings.TrimSuffix(action, "_rate_limited")
+}
+
+// Dispatch 按 action 的后缀同步执行，或放入异步协程池、限速队列后立即返回
+func (apiMap *ApiMap) Dispatch(action string, bot int64, params gjson.Result) Result {
+	var jobs chan apiJob
+	switch {
+	case strings.HasSuffix(action, "_async"):
+		jobs = asyncJobs
+	case strings.HasSuffix(action, "_rate_limited"):
+		jobs = rateLimitedJobs
+	default:
+		return apiMap.CallApi(action, bot, params)
+	}
+	job := apiJob{action: actionName(action), bot: bot, params: params}
+	if !apiMap.Has(job.action) {
+		return makeError(ErrUnsupported, "no such action: %s", job.action)
+	}
+	select {
+	case jobs <- job:
+		return makeAsync()
+	default:
+		return makeError(ErrRateLimited, "too many queued calls")
+	}
+}
+
+func makeAsync() Result {
+	return Result{
+		Status:  "async",
+		Retcode: 1,
+		Data:    nil,
+		Echo:    nil,
+	}
+}
+
+// runJobs 依次执行队列中的调用，interval 不为 0 时每次调用后等待
+func runJobs(jobs chan apiJob, interval time.Duration) {
+	for job := range jobs {
+		runJob(job)
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+func runJob(job apiJob) {
+	defer func() {
+		if err := recover(); err != nil {
+			ERROR("[异步][%v] API: %v Error: %v", job.bot, job.action, err)
+		}
+	}()
+	ret := apiMap.CallApi(job.action, job.bot, job.params)
+	DEBUG("[异步][%v] API: %v Status: %v Retcode: %v", job.bot, job.action, ret.Status, ret.Retcode)
+}
@@ -0,0 +1,48 @@
+package onebot
+
+// XQ 当前使用的框架后端，Windows 下由 core 包的 cgo 实现替换
+var XQ Backend = NewMemoryBackend()
+
+// Backend 先驱框架接口，Routers 与事件处理只通过它调用框架
+// 方法与 core 包同名同参，方便对照先驱文档
+type Backend interface {
+	// 消息
+	SendMsgEX_V2(selfID int64, messageType int64, groupID int64, userID int64, message string, bubble int64, anonymous bool, jsonData string) string
+	WithdrawMsgEX(selfID int64, subType int64, groupID int64, userID int64, messageNum int64, messageID int64, time int64) string
+	SendXML(selfID int64, anonymous int64, messageType int64, groupID int64, userID int64, xmlData string, nothing int64)
+	SendJSON(selfID int64, anonymous int64, messageType int64, groupID int64, userID int64, jsonData string)
+	ShakeWindow(selfID int64, userID int64) bool
+	UpVote(selfID int64, userID int64) string
+
+	// 群管理
+	KickGroupMBR(selfID int64, groupID int64, userID int64, rejectAddRequest bool)
+	ShutUP(selfID int64, groupID int64, userID int64, time int64)
+	SetAnon(selfID int64, groupID int64, enable bool) bool
+	SetGroupCard(selfID int64, groupID int64, userID int64, card string) bool
+	QuitGroup(selfID int64, groupID int64)
+
+	// 请求处理
+	HandleFriendEvent(selfID int64, userID int64, approve int64, remark string)
+	HandleGroupEvent(selfID int64, subType int64, userID int64, groupID int64, flag int64, approve int64, remark string)
+
+	// 信息获取
+	GetQQList() string
+	IsOnline(selfID int64, userID int64) bool
+	GetNick(selfID int64, userID int64) string
+	GetGender(selfID int64, userID int64) int64
+	GetAge(selfID int64, userID int64) int64
+	GetFriendList(selfID int64) string
+	GetGroupList(selfID int64) string
+	GetGroupName(selfID int64, groupID int64) string
+	GetGroupMemberNum(selfID int64, groupID int64) string
+	GetGroupMemberList_B(selfID int64, groupID int64) string
+	GetGroupMemberList_C(selfID int64, groupID int64) string
+
+	// 网页凭证
+	GetCookies(selfID int64) string
+	GetGroupPsKey(selfID int64) string
+	GetZonePsKey(selfID int64) string
+
+	// 日志
+	OutPutLog(message string)
+}
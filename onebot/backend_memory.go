@@ -0,0 +1,484 @@
+package onebot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend 纯Go的内存假框架，不依赖先驱也能跑通 OneBot 的全部流程
+// 所有会改变状态的调用都会记录在 Calls 里，便于测试断言与模拟器回放
+type MemoryBackend struct {
+	sync.Mutex
+	Bots    []int64                `json:"bots" yaml:"bots"`
+	Users   map[int64]*MemoryUser  `json:"users" yaml:"users"`
+	Friends []int64                `json:"friends" yaml:"friends"`
+	Groups  map[int64]*MemoryGroup `json:"groups" yaml:"groups"`
+	Cookies string                 `json:"cookies" yaml:"cookies"`
+
+	// OnCall 在持有锁时回调，回调内不可再调用本后端的方法
+	Calls  []BackendCall     `json:"-" yaml:"-"`
+	OnCall func(BackendCall) `json:"-" yaml:"-"`
+	Log    io.Writer         `json:"-" yaml:"-"`
+
+	msgSeq int64
+}
+
+// MemoryUser 假框架中的QQ用户资料
+type MemoryUser struct {
+	Nickname string `json:"nickname" yaml:"nickname"`
+	Sex      int64  `json:"sex" yaml:"sex"`
+	Age      int64  `json:"age" yaml:"age"`
+}
+
+// MemoryGroup 假框架中的群
+type MemoryGroup struct {
+	Name      string                  `json:"name" yaml:"name"`
+	MaxMember int64                   `json:"max_member" yaml:"max_member"`
+	Owner     int64                   `json:"owner" yaml:"owner"`
+	Admins    []int64                 `json:"admins" yaml:"admins"`
+	Members   map[int64]*MemoryMember `json:"members" yaml:"members"`
+	WholeBan  bool                    `json:"whole_ban" yaml:"whole_ban"`
+	Anonymous bool                    `json:"anonymous" yaml:"anonymous"`
+}
+
+// MemoryMember 假框架中的群成员
+type MemoryMember struct {
+	Card         string `json:"card" yaml:"card"`
+	Level        string `json:"level" yaml:"level"`
+	JoinTime     int64  `json:"join_time" yaml:"join_time"`
+	LastSentTime int64  `json:"last_sent_time" yaml:"last_sent_time"`
+	BanUntil     int64  `json:"ban_until" yaml:"ban_until"`
+}
+
+// BackendCall 一次对框架的调用记录
+type BackendCall struct {
+	Time   int64                  `json:"time" yaml:"time"`
+	Method string                 `json:"method" yaml:"method"`
+	Args   map[string]interface{} `json:"args" yaml:"args"`
+	Return interface{}            `json:"return,omitempty" yaml:"return,omitempty"`
+}
+
+// NewMemoryBackend 创建一个空的假框架
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		Users:  map[int64]*MemoryUser{},
+		Groups: map[int64]*MemoryGroup{},
+		Log:    os.Stdout,
+	}
+}
+
+// record 记录一次调用，调用方需持有锁
+func (m *MemoryBackend) record(method string, ret interface{}, args map[string]interface{}) {
+	call := BackendCall{
+		Time:   time.Now().Unix(),
+		Method: method,
+		Args:   args,
+		Return: ret,
+	}
+	m.Calls = append(m.Calls, call)
+	if m.OnCall != nil {
+		m.OnCall(call)
+	}
+}
+
+// CallsOf 返回指定方法的全部调用记录
+func (m *MemoryBackend) CallsOf(method string) []BackendCall {
+	m.Lock()
+	defer m.Unlock()
+	calls := []BackendCall{}
+	for _, call := range m.Calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// group 取群，不存在时返回nil，调用方需持有锁
+func (m *MemoryBackend) group(groupID int64) *MemoryGroup {
+	if m.Groups == nil {
+		return nil
+	}
+	return m.Groups[groupID]
+}
+
+func (m *MemoryBackend) nickname(userID int64) string {
+	if u, ok := m.Users[userID]; ok {
+		return u.Nickname
+	}
+	return Int2Str(userID)
+}
+
+func (m *MemoryBackend) SendMsgEX_V2(selfID int64, messageType int64, groupID int64, userID int64, message string, bubble int64, anonymous bool, jsonData string) string {
+	m.Lock()
+	defer m.Unlock()
+	m.msgSeq++
+	ret := fmt.Sprintf(`{"sendok":true,"msgid":%d,"msgno":%d}`, 1000000+m.msgSeq, m.msgSeq)
+	m.record("SendMsgEX_V2", ret, map[string]interface{}{
+		"self_id":      selfID,
+		"message_type": messageType,
+		"group_id":     groupID,
+		"user_id":      userID,
+		"message":      message,
+		"bubble":       bubble,
+		"anonymous":    anonymous,
+		"json_data":    jsonData,
+	})
+	return ret
+}
+
+func (m *MemoryBackend) WithdrawMsgEX(selfID int64, subType int64, groupID int64, userID int64, messageNum int64, messageID int64, time int64) string {
+	m.Lock()
+	defer m.Unlock()
+	m.record("WithdrawMsgEX", nil, map[string]interface{}{
+		"self_id":     selfID,
+		"sub_type":    subType,
+		"group_id":    groupID,
+		"user_id":     userID,
+		"message_num": messageNum,
+		"message_id":  messageID,
+		"time":        time,
+	})
+	return ""
+}
+
+func (m *MemoryBackend) SendXML(selfID int64, anonymous int64, messageType int64, groupID int64, userID int64, xmlData string, nothing int64) {
+	m.Lock()
+	defer m.Unlock()
+	m.record("SendXML", nil, map[string]interface{}{
+		"self_id":      selfID,
+		"anonymous":    anonymous,
+		"message_type": messageType,
+		"group_id":     groupID,
+		"user_id":      userID,
+		"xml_data":     xmlData,
+	})
+}
+
+func (m *MemoryBackend) SendJSON(selfID int64, anonymous int64, messageType int64, groupID int64, userID int64, jsonData string) {
+	m.Lock()
+	defer m.Unlock()
+	m.record("SendJSON", nil, map[string]interface{}{
+		"self_id":      selfID,
+		"anonymous":    anonymous,
+		"message_type": messageType,
+		"group_id":     groupID,
+		"user_id":      userID,
+		"json_data":    jsonData,
+	})
+}
+
+func (m *MemoryBackend) ShakeWindow(selfID int64, userID int64) bool {
+	m.Lock()
+	defer m.Unlock()
+	m.record("ShakeWindow", true, map[string]interface{}{
+		"self_id": selfID,
+		"user_id": userID,
+	})
+	return true
+}
+
+func (m *MemoryBackend) UpVote(selfID int64, userID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	m.record("UpVote", nil, map[string]interface{}{
+		"self_id": selfID,
+		"user_id": userID,
+	})
+	return ""
+}
+
+func (m *MemoryBackend) KickGroupMBR(selfID int64, groupID int64, userID int64, rejectAddRequest bool) {
+	m.Lock()
+	defer m.Unlock()
+	if g := m.group(groupID); g != nil {
+		delete(g.Members, userID)
+	}
+	m.record("KickGroupMBR", nil, map[string]interface{}{
+		"self_id":            selfID,
+		"group_id":           groupID,
+		"user_id":            userID,
+		"reject_add_request": rejectAddRequest,
+	})
+}
+
+func (m *MemoryBackend) ShutUP(selfID int64, groupID int64, userID int64, time int64) {
+	m.Lock()
+	defer m.Unlock()
+	if g := m.group(groupID); g != nil {
+		if userID == 0 {
+			g.WholeBan = time != 0
+		} else if member, ok := g.Members[userID]; ok {
+			member.BanUntil = 0
+			if time != 0 {
+				member.BanUntil = nowUnix() + time
+			}
+		}
+	}
+	m.record("ShutUP", nil, map[string]interface{}{
+		"self_id":  selfID,
+		"group_id": groupID,
+		"user_id":  userID,
+		"time":     time,
+	})
+}
+
+func (m *MemoryBackend) SetAnon(selfID int64, groupID int64, enable bool) bool {
+	m.Lock()
+	defer m.Unlock()
+	if g := m.group(groupID); g != nil {
+		g.Anonymous = enable
+	}
+	m.record("SetAnon", true, map[string]interface{}{
+		"self_id":  selfID,
+		"group_id": groupID,
+		"enable":   enable,
+	})
+	return true
+}
+
+func (m *MemoryBackend) SetGroupCard(selfID int64, groupID int64, userID int64, card string) bool {
+	m.Lock()
+	defer m.Unlock()
+	if g := m.group(groupID); g != nil {
+		if member, ok := g.Members[userID]; ok {
+			member.Card = card
+		}
+	}
+	m.record("SetGroupCard", true, map[string]interface{}{
+		"self_id":  selfID,
+		"group_id": groupID,
+		"user_id":  userID,
+		"card":     card,
+	})
+	return true
+}
+
+func (m *MemoryBackend) QuitGroup(selfID int64, groupID int64) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.Groups, groupID)
+	m.record("QuitGroup", nil, map[string]interface{}{
+		"self_id":  selfID,
+		"group_id": groupID,
+	})
+}
+
+func (m *MemoryBackend) HandleFriendEvent(selfID int64, userID int64, approve int64, remark string) {
+	m.Lock()
+	defer m.Unlock()
+	if approve == 10 || approve == 40 {
+		m.Friends = append(m.Friends, userID)
+	}
+	m.record("HandleFriendEvent", nil, map[string]interface{}{
+		"self_id": selfID,
+		"user_id": userID,
+		"approve": approve,
+		"remark":  remark,
+	})
+}
+
+func (m *MemoryBackend) HandleGroupEvent(selfID int64, subType int64, userID int64, groupID int64, flag int64, approve int64, remark string) {
+	m.Lock()
+	defer m.Unlock()
+	m.record("HandleGroupEvent", nil, map[string]interface{}{
+		"self_id":  selfID,
+		"sub_type": subType,
+		"user_id":  userID,
+		"group_id": groupID,
+		"flag":     flag,
+		"approve":  approve,
+		"remark":   remark,
+	})
+}
+
+func (m *MemoryBackend) GetQQList() string {
+	m.Lock()
+	defer m.Unlock()
+	list := []string{}
+	for _, bot := range m.Bots {
+		list = append(list, Int2Str(bot))
+	}
+	return strings.Join(list, "\n")
+}
+
+func (m *MemoryBackend) IsOnline(selfID int64, userID int64) bool {
+	return true
+}
+
+func (m *MemoryBackend) GetNick(selfID int64, userID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	return m.nickname(userID)
+}
+
+func (m *MemoryBackend) GetGender(selfID int64, userID int64) int64 {
+	m.Lock()
+	defer m.Unlock()
+	if u, ok := m.Users[userID]; ok {
+		return u.Sex
+	}
+	return 0
+}
+
+func (m *MemoryBackend) GetAge(selfID int64, userID int64) int64 {
+	m.Lock()
+	defer m.Unlock()
+	if u, ok := m.Users[userID]; ok {
+		return u.Age
+	}
+	return 0
+}
+
+func (m *MemoryBackend) GetFriendList(selfID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	mems := []map[string]interface{}{}
+	for _, uin := range m.Friends {
+		mems = append(mems, map[string]interface{}{
+			"uin":  uin,
+			"name": m.nickname(uin),
+		})
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"result": []interface{}{map[string]interface{}{"mems": mems}},
+	})
+	return string(data)
+}
+
+func (m *MemoryBackend) GetGroupList(selfID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	list := map[string][]map[string]interface{}{
+		"create": {},
+		"manage": {},
+		"join":   {},
+	}
+	for _, groupID := range m.groupIDs() {
+		g := m.Groups[groupID]
+		info := map[string]interface{}{"gc": groupID, "gn": g.Name}
+		switch {
+		case g.Owner == selfID:
+			list["create"] = append(list["create"], info)
+		case containsInt64(g.Admins, selfID):
+			list["manage"] = append(list["manage"], info)
+		default:
+			list["join"] = append(list["join"], info)
+		}
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+func (m *MemoryBackend) GetGroupName(selfID int64, groupID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	if g := m.group(groupID); g != nil {
+		return g.Name
+	}
+	return ""
+}
+
+func (m *MemoryBackend) GetGroupMemberNum(selfID int64, groupID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	if g := m.group(groupID); g != nil {
+		return fmt.Sprintf("%d\n%d", len(g.Members), g.MaxMember)
+	}
+	return ""
+}
+
+func (m *MemoryBackend) GetGroupMemberList_B(selfID int64, groupID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	g := m.group(groupID)
+	if g == nil {
+		return ""
+	}
+	adm := []string{}
+	for _, admin := range g.Admins {
+		adm = append(adm, Int2Str(admin))
+	}
+	members := map[string]interface{}{}
+	for userID, member := range g.Members {
+		members[Int2Str(userID)] = map[string]interface{}{
+			"nk":  m.nickname(userID),
+			"cd":  member.Card,
+			"jt":  member.JoinTime,
+			"lst": member.LastSentTime,
+			"ll":  member.Level,
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"mem_num": len(g.Members),
+		"max_num": g.MaxMember,
+		"owner":   Int2Str(g.Owner),
+		"adm":     adm,
+		"members": members,
+	})
+	return string(data)
+}
+
+func (m *MemoryBackend) GetGroupMemberList_C(selfID int64, groupID int64) string {
+	m.Lock()
+	defer m.Unlock()
+	g := m.group(groupID)
+	if g == nil {
+		return ""
+	}
+	list := []map[string]interface{}{}
+	for userID, member := range g.Members {
+		level, _ := strconv.ParseInt(member.Level, 10, 64)
+		list = append(list, map[string]interface{}{"QQ": userID, "lv": level})
+	}
+	data, _ := json.Marshal(map[string]interface{}{"list": list})
+	return string(data)
+}
+
+func (m *MemoryBackend) GetCookies(selfID int64) string {
+	return m.Cookies
+}
+
+func (m *MemoryBackend) GetGroupPsKey(selfID int64) string {
+	return ""
+}
+
+func (m *MemoryBackend) GetZonePsKey(selfID int64) string {
+	return ""
+}
+
+func (m *MemoryBackend) OutPutLog(message string) {
+	if m.Log != nil {
+		fmt.Fprintf(m.Log, "%s %s\n", time.Now().Format("2006-01-02 15:04:05"), message)
+	}
+}
+
+// groupIDs 按群号排序，保证输出稳定，调用方需持有锁
+func (m *MemoryBackend) groupIDs() []int64 {
+	ids := []int64{}
+	for groupID := range m.Groups {
+		ids = append(ids, groupID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func containsInt64(list []int64, v int64) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func nowUnix() int64 {
+	return time.Now().Unix()
+}
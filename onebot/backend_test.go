@@ -0,0 +1,143 @@
+package onebot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// newTestBot 用内存假框架与临时数据库搭建一个机器人，上报的事件写入返回的 chan
+func newTestBot(t *testing.T) (*MemoryBackend, chan gjson.Result) {
+	backend := testBackend()
+	bot := testBotYaml(testBot)
+	events := captureEvents(bot)
+	useBackend(t, backend)
+	useConf(t, bot)
+	openTestDB(t, bot)
+	return backend, events
+}
+
+func TestSendGetDeleteMsg(t *testing.T) {
+	backend, _ := newTestBot(t)
+
+	ret := callApi(t, "send_msg", `{"message_type":"group","group_id":30001,"message":"hi[CQ:at,qq=20002]"}`)
+	if ret.Get("status").String() != "ok" {
+		t.Fatalf("send_msg: %v", ret.Raw)
+	}
+	id := ret.Get("data.message_id").Int()
+	if id == 0 {
+		t.Fatalf("send_msg message_id = 0")
+	}
+	sends := backend.CallsOf("SendMsgEX_V2")
+	if len(sends) != 1 {
+		t.Fatalf("SendMsgEX_V2 called %d times", len(sends))
+	}
+	// 先驱的 at 后面会补一个空格
+	if got := sends[0].Args["message"]; got != "hi[@20002] " {
+		t.Errorf("SendMsgEX_V2 message = %q", got)
+	}
+	if got := sends[0].Args["group_id"]; got != testGroup {
+		t.Errorf("SendMsgEX_V2 group_id = %v", got)
+	}
+
+	ret = callApi(t, "get_msg", `{"message_id":`+Int2Str(id)+`}`)
+	if ret.Get("status").String() != "ok" {
+		t.Fatalf("get_msg: %v", ret.Raw)
+	}
+	if got := ret.Get("data.raw_message").String(); got != "hi[CQ:at,qq=20002] " {
+		t.Errorf("get_msg raw_message = %q", got)
+	}
+	if got := ret.Get("data.message_type").String(); got != "group" {
+		t.Errorf("get_msg message_type = %q", got)
+	}
+	if got := ret.Get("data.sender.user_id").Int(); got != testBot {
+		t.Errorf("get_msg sender.user_id = %v", got)
+	}
+
+	ret = callApi(t, "delete_msg", `{"message_id":`+Int2Str(id)+`}`)
+	if ret.Get("status").String() != "ok" {
+		t.Fatalf("delete_msg: %v", ret.Raw)
+	}
+	withdraws := backend.CallsOf("WithdrawMsgEX")
+	if len(withdraws) != 1 {
+		t.Fatalf("WithdrawMsgEX called %d times", len(withdraws))
+	}
+	sent := gjson.Parse(sends[0].Return.(string))
+	if got := withdraws[0].Args["message_num"]; got != sent.Get("msgno").Int() {
+		t.Errorf("WithdrawMsgEX message_num = %v", got)
+	}
+	if got := withdraws[0].Args["message_id"]; got != sent.Get("msgid").Int() {
+		t.Errorf("WithdrawMsgEX message_id = %v", got)
+	}
+
+	ret = callApi(t, "get_msg", `{"message_id":12345}`)
+	if ret.Get("retcode").Int() != ErrNotFound.Retcode {
+		t.Errorf("get_msg unknown id: %v", ret.Raw)
+	}
+}
+
+func TestGroupMessageEvent(t *testing.T) {
+	_, events := newTestBot(t)
+
+	XQEvent(testBot, 2, 0, testGroup, testOwner, 0, "hi[@10001]", 7, 2000007, "", time.Now().Unix(), 0)
+	e := nextEvent(t, events)
+	if e.Get("post_type").String() != "message" || e.Get("message_type").String() != "group" {
+		t.Fatalf("event: %v", e.Raw)
+	}
+	if got := e.Get("raw_message").String(); got != "hi[CQ:at,qq=10001]" {
+		t.Errorf("raw_message = %q", got)
+	}
+	if got := e.Get("message.1.data.qq").String(); got != "10001" {
+		t.Errorf("message[1] = %v", e.Get("message.1").Raw)
+	}
+	if got := e.Get("sender.role").String(); got != "owner" {
+		t.Errorf("sender.role = %q", got)
+	}
+	id := e.Get("message_id").Int()
+	if id == 0 {
+		t.Fatalf("message_id = 0")
+	}
+
+	ret := callApi(t, "get_msg", `{"message_id":`+Int2Str(id)+`}`)
+	if got := ret.Get("data.real_id").Int(); got != 2000007 {
+		t.Errorf("get_msg real_id = %v", got)
+	}
+
+	// 先驱的撤回事件中 userID 是操作者，noticeID 是消息的发送者
+	XQEvent(testBot, 9, 2, testGroup, testOwner, testOwner, "", 7, 2000007, "", time.Now().Unix(), 0)
+	e = nextEvent(t, events)
+	if e.Get("notice_type").String() != "group_recall" {
+		t.Fatalf("event: %v", e.Raw)
+	}
+	if got := e.Get("message_id").Int(); got != id {
+		t.Errorf("group_recall message_id = %v, want %v", got, id)
+	}
+}
+
+func TestPrivateMessageEvent(t *testing.T) {
+	_, events := newTestBot(t)
+
+	XQEvent(testBot, 1, 0, 0, testOwner, 0, "hello", 3, 3000003, "", time.Now().Unix(), 0)
+	e := nextEvent(t, events)
+	if e.Get("message_type").String() != "private" || e.Get("sub_type").String() != "friend" {
+		t.Fatalf("event: %v", e.Raw)
+	}
+	if got := e.Get("user_id").Int(); got != testOwner {
+		t.Errorf("user_id = %v", got)
+	}
+	if got := e.Get("sender.nickname").String(); got != "主人" {
+		t.Errorf("sender.nickname = %q", got)
+	}
+	if e.Get("message_id").Int() == 0 {
+		t.Errorf("message_id = 0")
+	}
+}
+
+func TestDefaultQQ(t *testing.T) {
+	backend, _ := newTestBot(t)
+	backend.Bots = []int64{10002, testBot}
+	if got := DefaultQQ(); got != 10002 {
+		t.Errorf("DefaultQQ() = %v", got)
+	}
+}
@@ -0,0 +1,128 @@
+package onebot
+
+import (
+	"yaya/core"
+)
+
+func init() {
+	XQ = xqBackend{}
+	core.Create = XQCreate
+	core.Event = XQEvent
+	core.DestroyPlugin = XQDestroyPlugin
+	core.SetUp = XQSetUp
+}
+
+// xqBackend 先驱框架的 cgo 实现，直接转发到 core 包
+type xqBackend struct{}
+
+func (xqBackend) SendMsgEX_V2(selfID int64, messageType int64, groupID int64, userID int64, message string, bubble int64, anonymous bool, jsonData string) string {
+	return core.SendMsgEX_V2(selfID, messageType, groupID, userID, message, bubble, anonymous, jsonData)
+}
+
+func (xqBackend) WithdrawMsgEX(selfID int64, subType int64, groupID int64, userID int64, messageNum int64, messageID int64, time int64) string {
+	return core.WithdrawMsgEX(selfID, subType, groupID, userID, messageNum, messageID, time)
+}
+
+func (xqBackend) SendXML(selfID int64, anonymous int64, messageType int64, groupID int64, userID int64, xmlData string, nothing int64) {
+	core.SendXML(selfID, anonymous, messageType, groupID, userID, xmlData, nothing)
+}
+
+func (xqBackend) SendJSON(selfID int64, anonymous int64, messageType int64, groupID int64, userID int64, jsonData string) {
+	core.SendJSON(selfID, anonymous, messageType, groupID, userID, jsonData)
+}
+
+func (xqBackend) ShakeWindow(selfID int64, userID int64) bool {
+	return core.ShakeWindow(selfID, userID)
+}
+
+func (xqBackend) UpVote(selfID int64, userID int64) string {
+	return core.UpVote(selfID, userID)
+}
+
+func (xqBackend) KickGroupMBR(selfID int64, groupID int64, userID int64, rejectAddRequest bool) {
+	core.KickGroupMBR(selfID, groupID, userID, rejectAddRequest)
+}
+
+func (xqBackend) ShutUP(selfID int64, groupID int64, userID int64, time int64) {
+	core.ShutUP(selfID, groupID, userID, time)
+}
+
+func (xqBackend) SetAnon(selfID int64, groupID int64, enable bool) bool {
+	return core.SetAnon(selfID, groupID, enable)
+}
+
+func (xqBackend) SetGroupCard(selfID int64, groupID int64, userID int64, card string) bool {
+	return core.SetGroupCard(selfID, groupID, userID, card)
+}
+
+func (xqBackend) QuitGroup(selfID int64, groupID int64) {
+	core.QuitGroup(selfID, groupID)
+}
+
+func (xqBackend) HandleFriendEvent(selfID int64, userID int64, approve int64, remark string) {
+	core.HandleFriendEvent(selfID, userID, approve, remark)
+}
+
+func (xqBackend) HandleGroupEvent(selfID int64, subType int64, userID int64, groupID int64, flag int64, approve int64, remark string) {
+	core.HandleGroupEvent(selfID, subType, userID, groupID, flag, approve, remark)
+}
+
+func (xqBackend) GetQQList() string {
+	return core.GetQQList()
+}
+
+func (xqBackend) IsOnline(selfID int64, userID int64) bool {
+	return core.IsOnline(selfID, userID)
+}
+
+func (xqBackend) GetNick(selfID int64, userID int64) string {
+	return core.GetNick(selfID, userID)
+}
+
+func (xqBackend) GetGender(selfID int64, userID int64) int64 {
+	return core.GetGender(selfID, userID)
+}
+
+func (xqBackend) GetAge(selfID int64, userID int64) int64 {
+	return core.GetAge(selfID, userID)
+}
+
+func (xqBackend) GetFriendList(selfID int64) string {
+	return core.GetFriendList(selfID)
+}
+
+func (xqBackend) GetGroupList(selfID int64) string {
+	return core.GetGroupList(selfID)
+}
+
+func (xqBackend) GetGroupName(selfID int64, groupID int64) string {
+	return core.GetGroupName(selfID, groupID)
+}
+
+func (xqBackend) GetGroupMemberNum(selfID int64, groupID int64) string {
+	return core.GetGroupMemberNum(selfID, groupID)
+}
+
+func (xqBackend) GetGroupMemberList_B(selfID int64, groupID int64) string {
+	return core.GetGroupMemberList_B(selfID, groupID)
+}
+
+func (xqBackend) GetGroupMemberList_C(selfID int64, groupID int64) string {
+	return core.GetGroupMemberList_C(selfID, groupID)
+}
+
+func (xqBackend) GetCookies(selfID int64) string {
+	return core.GetCookies(selfID)
+}
+
+func (xqBackend) GetGroupPsKey(selfID int64) string {
+	return core.GetGroupPsKey(selfID)
+}
+
+func (xqBackend) GetZonePsKey(selfID int64) string {
+	return core.GetZonePsKey(selfID)
+}
+
+func (xqBackend) OutPutLog(message string) {
+	core.OutPutLog(message)
+}
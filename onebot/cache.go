@@ -0,0 +1,501 @@
+package onebot
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// defaultMemberTTL 未设置 member_ttl 时群成员缓存的有效期，单位秒
+const defaultMemberTTL = 3600
+
+// botCache 每个机器人的群、群成员与好友缓存，保存在内存与机器人的数据库中
+// 启动时先从数据库恢复，再从先驱重新获取；之后由通知事件更新，群成员过期后重新获取
+type botCache struct {
+	bot     *BotYaml
+	lock    sync.Mutex
+	dbLock  sync.Mutex
+	groups  map[int64]XGroupInfo
+	members map[int64]*memberGroup
+	friends map[int64]XFriend
+	stats   map[int64]map[int64]XMemberStat
+	loading map[int64]bool // 正在后台重新获取成员列表的群
+	writers sync.WaitGroup // 后台更新缓存与写入数据库的协程
+}
+
+type memberGroup struct {
+	Time    time.Time
+	Members map[int64]XGroupMember
+}
+
+func newBotCache(bot *BotYaml) *botCache {
+	return &botCache{
+		bot:     bot,
+		groups:  map[int64]XGroupInfo{},
+		members: map[int64]*memberGroup{},
+		friends: map[int64]XFriend{},
+		stats:   map[int64]map[int64]XMemberStat{},
+		loading: map[int64]bool{},
+	}
+}
+
+func memberTTL() time.Duration {
+	if Conf == nil || Conf.Cache == nil || Conf.Cache.MemberTTL <= 0 {
+		return defaultMemberTTL * time.Second
+	}
+	return time.Duration(Conf.Cache.MemberTTL) * time.Second
+}
+
+// restore 从数据库恢复上次的缓存
+func (c *botCache) restore() {
+	if c == nil || c.bot.DB == nil {
+		return
+	}
+	var (
+		groups  []XGroupInfo
+		members []XGroupMember
+		friends []XFriend
+		stats   []XMemberStat
+	)
+	c.bot.dbSelectList(&groups, "1=1")
+	c.bot.dbSelectList(&members, "1=1")
+	c.bot.dbSelectList(&friends, "1=1")
+	c.bot.dbSelectList(&stats, "1=1")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, g := range groups {
+		c.groups[g.GroupID] = g
+	}
+	for _, m := range members {
+		if c.members[m.GroupID] == nil {
+			c.members[m.GroupID] = &memberGroup{Time: time.Now(), Members: map[int64]XGroupMember{}}
+		}
+		c.members[m.GroupID].Members[m.UserID] = m
+	}
+	for _, f := range friends {
+		c.friends[f.UserID] = f
+	}
+	for _, stat := range stats {
+		if c.stats[stat.GroupID] == nil {
+			c.stats[stat.GroupID] = map[int64]XMemberStat{}
+		}
+		c.stats[stat.GroupID][stat.UserID] = stat
+	}
+	INFO("[缓存][%v] 从数据库恢复了 %v 个群、%v 个群成员、%v 个好友", c.bot.Bot, len(groups), len(members), len(friends))
+}
+
+// refresh 从先驱获取群列表、每个群的成员与好友列表
+func (c *botCache) refresh() {
+	if c == nil {
+		return
+	}
+	c.loadFriends()
+	for _, g := range c.loadGroups() {
+		c.loadMembers(g.GroupID)
+	}
+	INFO("[缓存][%v] 缓存了 %v 个群、%v 个好友", c.bot.Bot, len(c.groupList()), len(c.friendList()))
+}
+
+// loadGroups 获取群列表，获取失败时保留旧的缓存
+func (c *botCache) loadGroups() []XGroupInfo {
+	list := XQ.GetGroupList(c.bot.Bot)
+	if list == "" || !gjson.Valid(list) {
+		WARN("[缓存][%v] 获取群列表失败", c.bot.Bot)
+		return c.groupList()
+	}
+	groups := map[int64]XGroupInfo{}
+	c.lock.Lock()
+	for _, g := range xqGroupList(gjson.Parse(list)) {
+		old := c.groups[g.GroupID]
+		g.MemberCount, g.MaxMemberCount = old.MemberCount, old.MaxMemberCount
+		groups[g.GroupID] = g
+	}
+	// 已经不在的群
+	for groupID := range c.members {
+		if _, ok := groups[groupID]; !ok {
+			delete(c.members, groupID)
+		}
+	}
+	c.groups = groups
+	c.lock.Unlock()
+	c.background(c.saveGroups, "saveGroups()")
+	return c.groupList()
+}
+
+// loadGroup 获取单个群的信息
+func (c *botCache) loadGroup(groupID int64) XGroupInfo {
+	info := XGroupInfo{
+		GroupID:        groupID,
+		GroupName:      XQ.GetGroupName(c.bot.Bot, groupID),
+		MemberCount:    -1,
+		MaxMemberCount: -1,
+	}
+	if members := strings.Split(XQ.GetGroupMemberNum(c.bot.Bot, groupID), "\n"); len(members) == 2 {
+		info.MemberCount = Str2Int(members[0])
+		info.MaxMemberCount = Str2Int(members[1])
+	}
+	if info.GroupName != "" {
+		c.lock.Lock()
+		c.groups[groupID] = info
+		c.lock.Unlock()
+		c.background(c.saveGroups, "saveGroups()")
+	}
+	return info
+}
+
+// loadMembers 获取群成员列表，获取失败时保留旧的缓存
+func (c *botCache) loadMembers(groupID int64) *memberGroup {
+	list := XQ.GetGroupMemberList_B(c.bot.Bot, groupID)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if list == "" || !gjson.Valid(list) {
+		WARN("[缓存][%v] 获取群 %v 的成员列表失败", c.bot.Bot, groupID)
+		return c.members[groupID]
+	}
+	m := gjson.Parse(list)
+	group := &memberGroup{Time: time.Now(), Members: map[int64]XGroupMember{}}
+	for _, member := range xqMemberList(groupID, m) {
+		group.Members[member.UserID] = member
+	}
+	c.members[groupID] = group
+	if info, ok := c.groups[groupID]; ok {
+		info.MemberCount = m.Get("mem_num").Int()
+		info.MaxMemberCount = m.Get("max_num").Int()
+		c.groups[groupID] = info
+		c.background(c.saveGroups, "saveGroups()")
+	}
+	c.background(func() { c.saveMembers(groupID) }, "saveMembers()")
+	return group
+}
+
+// loadFriends 获取好友列表，获取失败时保留旧的缓存
+func (c *botCache) loadFriends() []XFriend {
+	list := XQ.GetFriendList(c.bot.Bot)
+	if list == "" || !gjson.Valid(list) {
+		WARN("[缓存][%v] 获取好友列表失败", c.bot.Bot)
+		return c.friendList()
+	}
+	friends := map[int64]XFriend{}
+	for _, f := range xqFriendList(gjson.Parse(list)) {
+		friends[f.UserID] = f
+	}
+	c.lock.Lock()
+	c.friends = friends
+	c.lock.Unlock()
+	c.background(c.saveFriends, "saveFriends()")
+	return c.friendList()
+}
+
+// group 查询群信息，noCache 为 true 或缓存中没有时从先驱获取
+func (c *botCache) group(groupID int64, noCache bool) XGroupInfo {
+	c.lock.Lock()
+	info, ok := c.groups[groupID]
+	c.lock.Unlock()
+	if noCache || !ok {
+		return c.loadGroup(groupID)
+	}
+	return info
+}
+
+// groupList 群列表，按群号排序
+func (c *botCache) groupList() []XGroupInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	groups := []XGroupInfo{}
+	for _, g := range c.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].GroupID < groups[j].GroupID })
+	return groups
+}
+
+// groupMembers 某个群的成员缓存，缓存中没有或 noCache 为 true 时从先驱获取，过期时先返回旧的缓存并在后台重新获取
+func (c *botCache) groupMembers(groupID int64, noCache bool) *memberGroup {
+	c.lock.Lock()
+	group := c.members[groupID]
+	c.lock.Unlock()
+	switch {
+	case noCache || group == nil:
+		group = c.loadMembers(groupID)
+	case time.Since(group.Time) > memberTTL():
+		c.reloadMembers(groupID)
+	}
+	return group
+}
+
+// reloadMembers 在后台重新获取过期的成员列表，同一个群同时只获取一次
+func (c *botCache) reloadMembers(groupID int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.loading[groupID] {
+		return
+	}
+	c.loading[groupID] = true
+	c.background(func() {
+		defer func() {
+			c.lock.Lock()
+			delete(c.loading, groupID)
+			c.lock.Unlock()
+		}()
+		c.loadMembers(groupID)
+	}, "reloadMembers()")
+}
+
+// member 查询群成员，缓存中没有或者获取失败时 ok 为 false
+func (c *botCache) member(groupID int64, userID int64, noCache bool) (XGroupMember, bool) {
+	if c == nil || groupID == 0 {
+		return XGroupMember{}, false
+	}
+	group := c.groupMembers(groupID, noCache)
+	if group == nil {
+		return XGroupMember{}, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	member, ok := group.Members[userID]
+	return c.withActivity(member), ok
+}
+
+// memberList 群成员列表，按入群时间排序，获取失败时 ok 为 false
+func (c *botCache) memberList(groupID int64, noCache bool) ([]XGroupMember, bool) {
+	group := c.groupMembers(groupID, noCache)
+	if group == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	members := []XGroupMember{}
+	for _, m := range group.Members {
+		members = append(members, c.withActivity(m))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].JoinTime != members[j].JoinTime {
+			return members[i].JoinTime < members[j].JoinTime
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, true
+}
+
+// friendList 好友列表，按QQ号排序
+func (c *botCache) friendList() []XFriend {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	friends := []XFriend{}
+	for _, f := range c.friends {
+		friends = append(friends, f)
+	}
+	sort.Slice(friends, func(i, j int) bool { return friends[i].UserID < friends[j].UserID })
+	return friends
+}
+
+// increaseMember 群成员增加，成员在下次使用时重新获取，机器人自己加群时获取群信息
+func (c *botCache) increaseMember(groupID int64, userID int64) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	delete(c.members, groupID)
+	c.lock.Unlock()
+	if userID == c.bot.Bot {
+		c.loadGroup(groupID)
+	}
+}
+
+// setRole 管理员变动
+func (c *botCache) setRole(groupID int64, userID int64, role string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	group := c.members[groupID]
+	if group == nil {
+		c.lock.Unlock()
+		return
+	}
+	if member, ok := group.Members[userID]; ok {
+		member.Role = role
+		group.Members[userID] = member
+	}
+	c.lock.Unlock()
+	c.background(func() { c.saveMembers(groupID) }, "saveMembers()")
+}
+
+// removeMember 群成员减少，机器人自己离开时删除整个群
+func (c *botCache) removeMember(groupID int64, userID int64) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	if userID == c.bot.Bot {
+		delete(c.groups, groupID)
+		delete(c.members, groupID)
+		c.lock.Unlock()
+		c.background(c.saveGroups, "saveGroups()")
+		c.background(func() { c.saveMembers(groupID) }, "saveMembers()")
+		return
+	}
+	if group := c.members[groupID]; group != nil {
+		delete(group.Members, userID)
+	}
+	if info, ok := c.groups[groupID]; ok && info.MemberCount > 0 {
+		info.MemberCount--
+		c.groups[groupID] = info
+	}
+	c.lock.Unlock()
+	c.background(c.saveGroups, "saveGroups()")
+	c.background(func() { c.saveMembers(groupID) }, "saveMembers()")
+}
+
+// addFriend 新增好友
+func (c *botCache) addFriend(userID int64) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	c.friends[userID] = XFriend{UserID: userID, Nickname: XQ.GetNick(c.bot.Bot, userID)}
+	c.lock.Unlock()
+	c.background(c.saveFriends, "saveFriends()")
+}
+
+// background 在后台协程中更新缓存或写入数据库，wait 可以等待它们结束
+func (c *botCache) background(entry func(), label string) {
+	if c == nil {
+		return
+	}
+	c.writers.Add(1)
+	go func() {
+		defer c.writers.Done()
+		ProtectRun(entry, label)
+	}()
+}
+
+// wait 等待后台更新缓存与写入数据库的协程结束
+func (c *botCache) wait() {
+	if c != nil {
+		c.writers.Wait()
+	}
+}
+
+// saveGroups 把群列表写入数据库
+func (c *botCache) saveGroups() {
+	if c.bot.DB == nil {
+		return
+	}
+	c.dbLock.Lock()
+	defer c.dbLock.Unlock()
+	groups := c.groupList()
+	if err := c.bot.dbTx(func(tx *sql.Tx) {
+		txExec(tx, "DELETE FROM XGroupInfo")
+		txInsertList(tx, groups)
+	}); err != nil {
+		ERROR("[缓存][%v] 群列表写入数据库失败: %v", c.bot.Bot, err)
+	}
+}
+
+// saveMembers 把一个群的成员写入数据库
+func (c *botCache) saveMembers(groupID int64) {
+	if c.bot.DB == nil {
+		return
+	}
+	c.dbLock.Lock()
+	defer c.dbLock.Unlock()
+	var members []XGroupMember
+	c.lock.Lock()
+	if group := c.members[groupID]; group != nil {
+		for _, m := range group.Members {
+			members = append(members, m)
+		}
+	}
+	c.lock.Unlock()
+	if err := c.bot.dbTx(func(tx *sql.Tx) {
+		txExec(tx, "DELETE FROM XGroupMember WHERE group_id=?", groupID)
+		txInsertList(tx, members)
+	}); err != nil {
+		ERROR("[缓存][%v] 群 %v 的成员写入数据库失败: %v", c.bot.Bot, groupID, err)
+	}
+}
+
+// saveFriends 把好友列表写入数据库
+func (c *botCache) saveFriends() {
+	if c.bot.DB == nil {
+		return
+	}
+	c.dbLock.Lock()
+	defer c.dbLock.Unlock()
+	friends := c.friendList()
+	if err := c.bot.dbTx(func(tx *sql.Tx) {
+		txExec(tx, "DELETE FROM XFriend")
+		txInsertList(tx, friends)
+	}); err != nil {
+		ERROR("[缓存][%v] 好友列表写入数据库失败: %v", c.bot.Bot, err)
+	}
+}
+
+// cache 机器人的缓存，bot 为 nil 时也为 nil
+func (bot *BotYaml) cache() *botCache {
+	if bot == nil {
+		return nil
+	}
+	return bot.Cache
+}
+
+// sender 消息事件中的 sender，群成员信息来自缓存
+func (bot *BotYaml) sender(groupID int64, userID int64) Event {
+	sender := Event{
+		"user_id":  userID,
+		"nickname": "unknown",
+		"sex":      "unknown",
+		"age":      0,
+	}
+	if bot == nil {
+		return sender
+	}
+	if groupID == 0 {
+		if nick := XQ.GetNick(bot.Bot, userID); nick != "" {
+			sender["nickname"] = nick
+		}
+		return sender
+	}
+	sender["area"] = ""
+	sender["card"] = ""
+	sender["level"] = ""
+	sender["role"] = "member"
+	sender["title"] = ""
+	if member, ok := bot.Cache.member(groupID, userID, false); ok {
+		sender["nickname"] = member.Nickname
+		sender["sex"] = member.Sex
+		sender["age"] = member.Age
+		sender["area"] = member.Area
+		sender["card"] = member.Card
+		sender["level"] = member.Level
+		sender["role"] = member.Role
+		sender["title"] = member.Title
+	}
+	return sender
+}
+
+// xqMember2cq OneBot 的群成员信息
+func xqMember2cq(m XGroupMember) map[string]interface{} {
+	return map[string]interface{}{
+		"group_id":          m.GroupID,
+		"user_id":           m.UserID,
+		"nickname":          m.Nickname,
+		"card":              m.Card,
+		"sex":               m.Sex,
+		"age":               m.Age,
+		"area":              m.Area,
+		"join_time":         m.JoinTime,
+		"last_sent_time":    m.LastSentTime,
+		"level":             m.Level,
+		"role":              m.Role,
+		"unfriendly":        m.Unfriendly,
+		"title":             m.Title,
+		"title_expire_time": m.TitleExpireTime,
+		"card_changeable":   m.CardChangeable,
+	}
+}
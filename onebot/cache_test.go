@@ -0,0 +1,80 @@
+package onebot
+
+import (
+	"os"
+	"testing"
+)
+
+// 成员缓存过期时先返回旧的，后台重新获取以后才是新的
+func TestMemberStaleRefresh(t *testing.T) {
+	backend := testBackend()
+	bot := testBotYaml(testBot)
+	useBackend(t, backend)
+	useConf(t, bot)
+	openTestDB(t, bot)
+	cache := bot.Cache
+
+	member, ok := cache.member(testGroup, testUser, false)
+	if !ok || member.Card != "路过" {
+		t.Fatalf("cold member = %+v, %v", member, ok)
+	}
+
+	backend.Lock()
+	backend.Groups[testGroup].Members[testUser].Card = "新名片"
+	backend.Unlock()
+	cache.lock.Lock()
+	cache.members[testGroup].Time = cache.members[testGroup].Time.Add(-memberTTL() * 2)
+	cache.lock.Unlock()
+
+	if member, _ := cache.member(testGroup, testUser, false); member.Card != "路过" {
+		t.Errorf("stale member card = %q, want the cached one", member.Card)
+	}
+	cache.wait()
+	if member, _ := cache.member(testGroup, testUser, false); member.Card != "新名片" {
+		t.Errorf("refreshed member card = %q", member.Card)
+	}
+	cache.lock.Lock()
+	loading := len(cache.loading)
+	cache.lock.Unlock()
+	if loading != 0 {
+		t.Errorf("loading = %v after refresh", loading)
+	}
+
+	// noCache 仍然立即重新获取
+	backend.Lock()
+	backend.Groups[testGroup].Members[testUser].Card = "再改"
+	backend.Unlock()
+	if member, _ := cache.member(testGroup, testUser, true); member.Card != "再改" {
+		t.Errorf("no_cache member card = %q", member.Card)
+	}
+}
+
+// 数据库打不开时缓存仍然从先驱获取
+func TestRefreshWithoutDB(t *testing.T) {
+	useBackend(t, testBackend())
+	bot := testBotYaml(testBot)
+	conf := useConf(t, bot)
+	appPath := AppPath
+	// 目录的位置是一个文件，数据库无法创建
+	AppPath = t.TempDir() + "/file"
+	if err := os.WriteFile(AppPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	AppPath += "/"
+	t.Cleanup(func() { AppPath = appPath })
+
+	conf.runDB()
+	bot.Cache.wait()
+	if bot.DB != nil {
+		t.Fatalf("database opened at %v", bot.DBPath)
+	}
+	if groups := bot.Cache.groupList(); len(groups) != 1 || groups[0].GroupID != testGroup {
+		t.Errorf("groups = %+v", groups)
+	}
+	if friends := bot.Cache.friendList(); len(friends) != 1 || friends[0].UserID != testOwner {
+		t.Errorf("friends = %+v", friends)
+	}
+	if member, ok := bot.Cache.member(testGroup, testUser, false); !ok || member.Card != "路过" {
+		t.Errorf("member = %+v, %v", member, ok)
+	}
+}
@@ -0,0 +1,58 @@
+package onebot
+
+import "github.com/tidwall/gjson"
+
+// SegmentCapability 发送消息时的消息段类型，Supported 为 false 的只会回复一条不支持的提示
+type SegmentCapability struct {
+	Type      string `json:"type"`
+	Supported bool   `json:"supported"`
+}
+
+// EventCapability XQEvent 会上报的事件类型
+type EventCapability struct {
+	PostType string   `json:"post_type"`
+	Type     string   `json:"type"`
+	SubTypes []string `json:"sub_types"`
+}
+
+// segmentCapabilities 由 segmentTypes 生成
+func segmentCapabilities() []SegmentCapability {
+	list := make([]SegmentCapability, 0, len(segmentTypes))
+	for _, t := range segmentTypes {
+		list = append(list, SegmentCapability{t.Type, t.Supported})
+	}
+	return list
+}
+
+// eventCapabilities 与 XQEvent 中的 switch 对应，修改时需要同步
+// Type 对应 message_type notice_type request_type meta_event_type
+var eventCapabilities = []EventCapability{
+	{"message", "private", []string{"friend", "group", "discuss", "other"}},
+	{"message", "group", []string{"normal"}},
+	{"message", "discuss", []string{"normal"}},
+	{"notice", "group_upload", []string{}},
+	{"notice", "group_admin", []string{"set", "unset"}},
+	{"notice", "group_decrease", []string{"leave", "kick"}},
+	{"notice", "group_increase", []string{"approve"}},
+	{"notice", "group_ban", []string{"ban", "lift_ban"}},
+	{"notice", "friend_add", []string{}},
+	{"notice", "group_recall", []string{}},
+	{"notice", "friend_recall", []string{}},
+	{"request", "friend", []string{}},
+	{"request", "group", []string{"add", "invite"}},
+	{"meta_event", "lifecycle", []string{"connect"}},
+	{"meta_event", "heartbeat", []string{}},
+}
+
+// capabilities get_supported_actions 与 HTTP 的 /_capabilities 返回的内容
+func capabilities() map[string]interface{} {
+	return map[string]interface{}{
+		"actions":  Actions(),
+		"segments": segmentCapabilities(),
+		"events":   eventCapabilities,
+	}
+}
+
+func (this *Routers) GetSupportedActions(bot *BotYaml, params gjson.Result) Result {
+	return makeOk(capabilities())
+}
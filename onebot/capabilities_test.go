@@ -0,0 +1,32 @@
+package onebot
+
+import (
+	"testing"
+	"time"
+)
+
+// 上报的消息事件都要在 eventCapabilities 中声明
+func TestMessageEventCapabilities(t *testing.T) {
+	bot := testBotYaml(testBot)
+	events := captureEvents(bot)
+	useBackend(t, testBackend())
+	useConf(t, bot)
+	openTestDB(t, bot)
+	for i, type_ := range []int64{0, 1, 2, 3, 4, 5, 7} {
+		XQEvent(testBot, type_, 0, testGroup, testOwner, 0, "hi", int64(i+1), 0, "", time.Now().Unix(), 0)
+		e := nextEvent(t, events)
+		found := false
+		for _, c := range eventCapabilities {
+			if c.PostType != e.Get("post_type").String() || c.Type != e.Get("message_type").String() {
+				continue
+			}
+			for _, sub := range c.SubTypes {
+				found = found || sub == e.Get("sub_type").String()
+			}
+		}
+		if !found {
+			t.Errorf("XQ type %v: %v %v %v not in eventCapabilities", type_,
+				e.Get("post_type"), e.Get("message_type"), e.Get("sub_type"))
+		}
+	}
+}
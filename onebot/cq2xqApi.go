@@ -4,13 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/tidwall/gjson"
-
-	"yaya/core"
 )
 
 var apiMap ApiMap
@@ -19,6 +17,8 @@ type Result struct {
 	Status  string      `json:"status"`
 	Retcode int64       `json:"retcode"`
 	Data    interface{} `json:"data"`
+	Message string      `json:"message,omitempty"`
+	Wording string      `json:"wording,omitempty"`
 	Echo    interface{} `json:"echo"`
 }
 
@@ -26,91 +26,121 @@ type Result struct {
 type Routers struct {
 }
 
-// ApiMap XQApi的name与method对应表
+// Param action 的参数说明，Type 为 int64 string bool message object 之一
+type Param struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+}
+
+// Action 一个 OneBot action 的注册信息
+type Action struct {
+	Name        string                                                        `json:"action"`
+	Description string                                                        `json:"description"`
+	Params      []Param                                                       `json:"params"`
+	Supported   bool                                                          `json:"supported"` // 先驱是否支持
+	Handler     func(this *Routers, bot *BotYaml, params gjson.Result) Result `json:"-"`
+}
+
+// ApiMap action 与 Action 的对应表
 type ApiMap struct {
-	this     Routers
-	name     []string
-	function []func(this *Routers, bot *BotYaml, params gjson.Result) Result
-}
-
-// Get 二分法获得字符串对应的method
-func (apiMap *ApiMap) Get(name string) func(this *Routers, bot *BotYaml, params gjson.Result) Result {
-	length := len(apiMap.name)
-	low := 0
-	high := length - 1
-	var fun func(this *Routers, bot *BotYaml, params gjson.Result) Result
-	for low <= high {
-		mid := (low + high) / 2
-		switch {
-		default:
-			fun = apiMap.function[mid]
-			return fun
-		case apiMap.name[mid] > name:
-			high = mid - 1
-		case apiMap.name[mid] < name:
-			low = mid + 1
-		}
-	}
-	return nil
+	this    Routers
+	actions map[string]*Action
+	names   []string
 }
 
-// Register 反射所有Routers的method并注册
-func (apiMap *ApiMap) Register(this *Routers) {
-	obj := reflect.TypeOf(this)
-	var i int = 0
-	for i < obj.NumMethod() {
-		apiMap.name = append(apiMap.name, obj.Method(i).Name)
-		apiMap.function = append(apiMap.function, obj.Method(i).Func.Interface().(func(this *Routers, bot *BotYaml, params gjson.Result) Result))
-		i++
+// Register 注册 action，同名的后注册的覆盖先注册的，别名用同一个 Handler 再注册一次即可
+func (apiMap *ApiMap) Register(actions ...Action) {
+	if apiMap.actions == nil {
+		apiMap.actions = map[string]*Action{}
 	}
-	sort.Sort(apiMap)
+	for i := range actions {
+		a := actions[i]
+		if a.Params == nil {
+			a.Params = []Param{}
+		}
+		if _, ok := apiMap.actions[a.Name]; !ok {
+			apiMap.names = append(apiMap.names, a.Name)
+		}
+		apiMap.actions[a.Name] = &a
+	}
+	sort.Strings(apiMap.names)
 }
 
-func (apiMap *ApiMap) Len() int { return len(apiMap.name) }
+// Get 获得 action 对应的注册信息，不存在时返回 nil
+func (apiMap *ApiMap) Get(action string) *Action {
+	return apiMap.actions[action]
+}
 
-func (apiMap *ApiMap) Less(i, j int) bool { return apiMap.name[i] < apiMap.name[j] }
+// Has 是否存在对应的XQApi
+func (apiMap *ApiMap) Has(action string) bool {
+	return apiMap.Get(action) != nil
+}
 
-func (apiMap *ApiMap) Swap(i, j int) {
-	apiMap.name[i], apiMap.name[j] = apiMap.name[j], apiMap.name[i]
-	apiMap.function[i], apiMap.function[j] = apiMap.function[j], apiMap.function[i]
+// List 按名字排序的所有 action
+func (apiMap *ApiMap) List() []*Action {
+	list := make([]*Action, 0, len(apiMap.names))
+	for _, name := range apiMap.names {
+		list = append(list, apiMap.actions[name])
+	}
+	return list
 }
 
 // CallApi 调用XQApi
 func (apiMap *ApiMap) CallApi(action string, bot int64, params gjson.Result) Result {
-	name := action2fname(action)
-	if apiMap.Get(name) == nil {
-		return makeError("no such api")
+	a := apiMap.Get(action)
+	if a == nil {
+		return makeError(ErrUnsupported, "no such action: %s", action)
+	}
+	if err := a.validate(params); err != "" {
+		return makeError(ErrBadRequest, "%s", err)
 	}
 	botConfig := Conf.getBotConfig(bot)
-	return apiMap.Get(name)(&apiMap.this, botConfig, params)
+	return a.Handler(&apiMap.this, botConfig, params)
 }
 
-// action2func OneBot的action转驼峰命名
-func action2fname(action string) string {
-	up := true
-	name := ""
-	for _, r := range action {
-		if up {
-			name += strings.ToUpper(string(r))
-			up = false
+// validate 检查必填参数与参数类型，HTTP 表单中的参数都是字符串，能转换的也算正确
+func (a *Action) validate(params gjson.Result) string {
+	for _, p := range a.Params {
+		v := params.Get(p.Name)
+		if !v.Exists() || v.Type == gjson.Null {
+			if p.Required {
+				return fmt.Sprintf("missing parameter '%s'", p.Name)
+			}
 			continue
 		}
-		if string(r) == "_" {
-			up = true
-		} else {
-			name += string(r)
+		ok := true
+		switch p.Type {
+		case "int64":
+			switch v.Type {
+			case gjson.Number:
+			case gjson.String:
+				_, err := strconv.ParseInt(v.Str, 10, 64)
+				ok = err == nil
+			default:
+				ok = false
+			}
+		case "bool":
+			switch v.Type {
+			case gjson.True, gjson.False:
+			case gjson.String, gjson.Number:
+				_, err := strconv.ParseBool(strings.ToLower(v.String()))
+				ok = err == nil
+			default:
+				ok = false
+			}
+		case "string":
+			ok = v.Type == gjson.String || v.Type == gjson.Number
+		case "message":
+			ok = v.Type == gjson.String || v.IsArray() || v.IsObject()
+		case "object":
+			ok = v.IsObject()
+		}
+		if !ok {
+			return fmt.Sprintf("parameter '%s' should be %s", p.Name, p.Type)
 		}
 	}
-	return name
-}
-
-func makeError(err string) Result {
-	return Result{
-		Status:  "failed",
-		Retcode: 100,
-		Data:    map[string]interface{}{"error": err},
-		Echo:    nil,
-	}
+	return ""
 }
 
 func makeOk(data interface{}) Result {
@@ -133,23 +163,20 @@ func (this *Routers) SendPrivateMsg(bot *BotYaml, params gjson.Result) Result {
 func (this *Routers) DeleteMsg(bot *BotYaml, params gjson.Result) Result {
 	var id int64 = params.Get("message_id").Int()
 	if id == 0 {
-		return makeError("无效'message_id'")
-	}
-	var xe XEvent
-	if bot.DB != nil {
-		bot.dbSelect(&xe, "id="+core.Int2Str(id))
-	}
-	if xe.ID == 0 {
-		return makeError("查询无此消息")
-	}
-	core.WithdrawMsgEX(
-		xe.SelfID,
-		xe.MseeageType,
-		xe.GroupID,
-		xe.UserID,
-		xe.MessageNum,
-		xe.MessageID,
-		xe.Time,
+		return makeError(ErrBadRequest, "invalid 'message_id'")
+	}
+	msg := bot.findMsg(id)
+	if msg.ID == 0 {
+		return makeError(ErrNotFound, "message not found")
+	}
+	XQ.WithdrawMsgEX(
+		msg.SelfID,
+		msg.MessageType,
+		msg.GroupID,
+		msg.UserID,
+		msg.MessageNum,
+		msg.MessageID,
+		msg.Time,
 	)
 	return makeOk(nil)
 }
@@ -157,45 +184,34 @@ func (this *Routers) DeleteMsg(bot *BotYaml, params gjson.Result) Result {
 func (this *Routers) GetMsg(bot *BotYaml, params gjson.Result) Result {
 	var id int64 = params.Get("message_id").Int()
 	if id == 0 {
-		return makeError("无效'message_id'")
+		return makeError(ErrBadRequest, "invalid 'message_id'")
 	}
-	var xe XEvent
-	if bot.DB != nil {
-		bot.dbSelect(&xe, "id="+core.Int2Str(id))
-	}
-	if xe.ID == 0 {
-		return makeError("查询无此消息")
+	msg := bot.findMsg(id)
+	if msg.ID == 0 {
+		return makeError(ErrNotFound, "message not found")
 	}
+	message := bot.xqReply2cq(xqCode2Message(msg.Message), msg.GroupID, msg.UserID)
 	return makeOk(map[string]interface{}{
-		"time":         xe.Time,
-		"message_type": xq2cqMsgType(xe.MseeageType),
-		"message_id":   xe.ID,
-		"real_id":      xe.MessageID,
-		"sender": Event{
-			"user_id":  xe.UserID,
-			"nickname": "unknown",
-			"sex":      "unknown",
-			"age":      0,
-			"area":     "",
-			"card":     "",
-			"level":    "",
-			"role":     "unknown",
-			"title":    "unknown",
-		},
-		"message": xe.Message,
+		"time":         msg.Time,
+		"message_type": xq2cqMsgType(msg.MessageType),
+		"message_id":   msg.ID,
+		"real_id":      msg.MessageID,
+		"sender":       bot.sender(msg.GroupID, msg.SenderID),
+		"message":      message,
+		"raw_message":  message.CQCode(),
 	})
 }
 
 func (this *Routers) GetForwardMsg(bot *BotYaml, params gjson.Result) Result {
-	return makeError("先驱不支持")
+	return makeError(ErrUnsupported, "not supported by XQ")
 }
 
 func (this *Routers) SendLike(bot *BotYaml, params gjson.Result) Result {
 	var userID int64 = params.Get("user_id").Int()
 	if userID == 0 {
-		return makeError("无效'user_id'")
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
-	core.UpVote(
+	XQ.UpVote(
 		bot.Bot,
 		userID,
 	)
@@ -207,12 +223,12 @@ func (this *Routers) SetGroupKick(bot *BotYaml, params gjson.Result) Result {
 	var userID int64 = params.Get("user_id").Int()
 	var rejectAddRequest bool = params.Get("reject_add_request").Bool()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
 	if userID == 0 {
-		return makeError("无效'user_id'")
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
-	core.KickGroupMBR(
+	XQ.KickGroupMBR(
 		bot.Bot,
 		groupID,
 		userID,
@@ -226,12 +242,12 @@ func (this *Routers) SetGroupBan(bot *BotYaml, params gjson.Result) Result {
 	var userID int64 = params.Get("user_id").Int()
 	var duration int64 = params.Get("duration").Int()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
 	if userID == 0 {
-		return makeError("无效'user_id'")
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
-	core.ShutUP(
+	XQ.ShutUP(
 		bot.Bot,
 		groupID,
 		userID,
@@ -241,24 +257,24 @@ func (this *Routers) SetGroupBan(bot *BotYaml, params gjson.Result) Result {
 }
 
 func (this *Routers) SetGroupAnonymousBan(bot *BotYaml, params gjson.Result) Result {
-	return makeError("先驱不支持")
+	return makeError(ErrUnsupported, "not supported by XQ")
 }
 
 func (this *Routers) SetGroupWholeBan(bot *BotYaml, params gjson.Result) Result {
 	var groupID int64 = params.Get("group_id").Int()
 	var enable bool = params.Get("enable").Bool()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
 	if enable {
-		core.ShutUP(
+		XQ.ShutUP(
 			bot.Bot,
 			groupID,
 			0,
 			1,
 		)
 	} else {
-		core.ShutUP(
+		XQ.ShutUP(
 			bot.Bot,
 			groupID,
 			0,
@@ -269,16 +285,16 @@ func (this *Routers) SetGroupWholeBan(bot *BotYaml, params gjson.Result) Result
 }
 
 func (this *Routers) SetGroupAdmin(bot *BotYaml, params gjson.Result) Result {
-	return makeError("先驱不支持")
+	return makeError(ErrUnsupported, "not supported by XQ")
 }
 
 func (this *Routers) SetGroupAnonymous(bot *BotYaml, params gjson.Result) Result {
 	var groupID int64 = params.Get("group_id").Int()
 	var enable bool = params.Get("enable").Bool()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
-	core.SetAnon(
+	XQ.SetAnon(
 		bot.Bot,
 		groupID,
 		enable,
@@ -289,14 +305,14 @@ func (this *Routers) SetGroupAnonymous(bot *BotYaml, params gjson.Result) Result
 func (this *Routers) SetGroupCard(bot *BotYaml, params gjson.Result) Result {
 	var groupID int64 = params.Get("group_id").Int()
 	var userID int64 = params.Get("user_id").Int()
-	var card string = params.Get("enable").Str
+	var card string = params.Get("card").String()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
 	if userID == 0 {
-		return makeError("无效'user_id'")
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
-	core.SetGroupCard(
+	XQ.SetGroupCard(
 		bot.Bot,
 		groupID,
 		userID,
@@ -306,15 +322,15 @@ func (this *Routers) SetGroupCard(bot *BotYaml, params gjson.Result) Result {
 }
 
 func (this *Routers) SetGroupName(bot *BotYaml, params gjson.Result) Result {
-	return makeError("先驱不支持")
+	return makeError(ErrUnsupported, "not supported by XQ")
 }
 
 func (this *Routers) SetGroupLeave(bot *BotYaml, params gjson.Result) Result {
 	var groupID int64 = params.Get("group_id").Int()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
-	core.QuitGroup(
+	XQ.QuitGroup(
 		bot.Bot,
 		groupID,
 	)
@@ -322,61 +338,64 @@ func (this *Routers) SetGroupLeave(bot *BotYaml, params gjson.Result) Result {
 }
 
 func (this *Routers) SetGroupSpecialTitle(bot *BotYaml, params gjson.Result) Result {
-	return makeError("先驱不支持")
+	return makeError(ErrUnsupported, "not supported by XQ")
 }
 
-func (this *Routers) SetFriendAddRequest(bot *BotYaml, params gjson.Result) Result {
-	var flag int64 = params.Get("flag").Int()
-	var approve bool = params.Get("approve").Bool()
-	var remark string = params.Get("remark").Str
-	if flag == 0 {
-		return makeError("无效'flag'")
-	}
+// xqApprove 先驱的处理方式 10同意 20拒绝
+func xqApprove(approve bool) int64 {
 	if approve {
-		core.HandleFriendEvent(
-			bot.Bot,
-			flag,
-			10,
-			remark,
-		)
-	} else {
-		core.HandleFriendEvent(
-			bot.Bot,
-			flag,
-			20,
-			remark,
-		)
+		return 10
+	}
+	return 20
+}
+
+func (this *Routers) SetFriendAddRequest(bot *BotYaml, params gjson.Result) Result {
+	flag, err := strconv.ParseInt(params.Get("flag").String(), 10, 64)
+	if err != nil || flag == 0 {
+		return makeError(ErrBadRequest, "invalid 'flag'")
 	}
+	// approve 默认为 true
+	approve := !params.Get("approve").Exists() || params.Get("approve").Bool()
+	XQ.HandleFriendEvent(
+		bot.Bot,
+		flag,
+		xqApprove(approve),
+		params.Get("remark").Str,
+	)
 	return makeOk(nil)
 }
 
 func (this *Routers) SetGroupAddRequest(bot *BotYaml, params gjson.Result) Result {
-	flag  := params.Get("flag").Str
-	if flag == "" {
-		return makeError("无效'flag'")
-	}
-	var approve int64
-	if params.Get("approve").Bool() {
-		approve = 10
-	}else {
-		approve = 20
-	}
-	reason := params.Get("reason").Str
-
-	split := strings.Split(flag, "|")
-	core.HandleGroupEvent(bot.Bot,
-		213,
-		params.Get("user_id").Int(),
-		core.Str2Int(split[1]),
-		core.Str2Int(split[2]),
-		approve,
-		reason,
+	split := strings.Split(params.Get("flag").String(), "|")
+	if len(split) != 4 {
+		return makeError(ErrBadRequest, "invalid 'flag'")
+	}
+	xqType := Str2Int(split[0])
+	if xqType != 213 && xqType != 214 {
+		return makeError(ErrBadRequest, "invalid 'flag'")
+	}
+	// sub_type 旧版本叫 type，填写时需要与 flag 一致
+	subType := params.Get("sub_type").Str
+	if subType == "" {
+		subType = params.Get("type").Str
+	}
+	if (subType == "add" && xqType != 213) || (subType == "invite" && xqType != 214) {
+		return makeError(ErrBadRequest, "'sub_type' does not match 'flag'")
+	}
+	approve := !params.Get("approve").Exists() || params.Get("approve").Bool()
+	XQ.HandleGroupEvent(bot.Bot,
+		xqType,
+		Str2Int(split[2]),
+		Str2Int(split[1]),
+		Str2Int(split[3]),
+		xqApprove(approve),
+		params.Get("reason").Str,
 	)
 	return makeOk(nil)
 }
 
 func (this *Routers) GetLoginInfo(bot *BotYaml, params gjson.Result) Result {
-	nickname := strings.Split(core.GetNick(
+	nickname := strings.Split(XQ.GetNick(
 		bot.Bot,
 		bot.Bot,
 	), "\n")[0]
@@ -389,19 +408,19 @@ func (this *Routers) GetLoginInfo(bot *BotYaml, params gjson.Result) Result {
 func (this *Routers) GetStrangerInfo(bot *BotYaml, params gjson.Result) Result {
 	var userID int64 = params.Get("user_id").Int()
 	if userID == 0 {
-		return makeError("无效'user_id'")
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
-	var nickname string = core.GetNick(
+	var nickname string = XQ.GetNick(
 		bot.Bot,
 		userID,
 	)
 	var sex string = xq2cqSex(
-		core.GetGender(
+		XQ.GetGender(
 			bot.Bot,
 			userID,
 		),
 	)
-	var age int64 = core.GetAge(
+	var age int64 = XQ.GetAge(
 		bot.Bot,
 		userID,
 	)
@@ -414,19 +433,13 @@ func (this *Routers) GetStrangerInfo(bot *BotYaml, params gjson.Result) Result {
 }
 
 func (this *Routers) GetFriendList(bot *BotYaml, params gjson.Result) Result {
-	var list string = core.GetFriendList(bot.Bot)
-	if list == "" {
-		return makeError("获取好友列表失败")
-	}
-	g := gjson.Parse(list)
 	friendList := []map[string]interface{}{}
-	for _, o := range g.Get("result.0.mems").Array() {
-		info := map[string]interface{}{
-			"user_id":  o.Get("uin").Int(),
-			"nickname": unicode2chinese(o.Get("name").Str),
-			"remark":   "unknown",
-		}
-		friendList = append(friendList, info)
+	for _, f := range bot.Cache.friendList() {
+		friendList = append(friendList, map[string]interface{}{
+			"user_id":  f.UserID,
+			"nickname": f.Nickname,
+			"remark":   f.Remark,
+		})
 	}
 	return makeOk(friendList)
 }
@@ -434,68 +447,26 @@ func (this *Routers) GetFriendList(bot *BotYaml, params gjson.Result) Result {
 func (this *Routers) GetGroupInfo(bot *BotYaml, params gjson.Result) Result {
 	var groupID int64 = params.Get("group_id").Int()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
-	}
-	var name string = core.GetGroupName(
-		bot.Bot,
-		groupID,
-	)
-	members := strings.Split(core.GetGroupMemberNum(
-		bot.Bot,
-		groupID,
-	), "\n")
-	var (
-		count int64
-		max   int64
-	)
-	if len(members) != 2 {
-		count = -1
-		max = -1
-	} else {
-		count = core.Str2Int(members[0])
-		max = core.Str2Int(members[1])
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
+	info := bot.Cache.group(groupID, params.Get("no_cache").Bool())
 	return makeOk(map[string]interface{}{
-		"group_id":         groupID,
-		"group_name":       name,
-		"member_count":     count,
-		"max_member_count": max,
+		"group_id":         info.GroupID,
+		"group_name":       info.GroupName,
+		"member_count":     info.MemberCount,
+		"max_member_count": info.MaxMemberCount,
 	})
 }
 
 func (this *Routers) GetGroupList(bot *BotYaml, params gjson.Result) Result {
-	list := core.GetGroupList(bot.Bot)
-	if list == "" {
-		return makeError("获取群列表失败")
-	}
-	g := gjson.Parse(list)
 	groupList := []map[string]interface{}{}
-	for _, o := range g.Get("create").Array() {
-		info := map[string]interface{}{
-			"group_id":         o.Get("gc").Int(),
-			"group_name":       unicode2chinese(o.Get("gn").Str),
-			"member_count":     0,
-			"max_member_count": 0,
-		}
-		groupList = append(groupList, info)
-	}
-	for _, o := range g.Get("manage").Array() {
-		info := map[string]interface{}{
-			"group_id":         o.Get("gc").Int(),
-			"group_name":       unicode2chinese(o.Get("gn").Str),
-			"member_count":     0,
-			"max_member_count": 0,
-		}
-		groupList = append(groupList, info)
-	}
-	for _, o := range g.Get("join").Array() {
-		info := map[string]interface{}{
-			"group_id":         o.Get("gc").Int(),
-			"group_name":       unicode2chinese(o.Get("gn").Str),
-			"member_count":     0,
-			"max_member_count": 0,
-		}
-		groupList = append(groupList, info)
+	for _, g := range bot.Cache.groupList() {
+		groupList = append(groupList, map[string]interface{}{
+			"group_id":         g.GroupID,
+			"group_name":       g.GroupName,
+			"member_count":     g.MemberCount,
+			"max_member_count": g.MaxMemberCount,
+		})
 	}
 	return makeOk(groupList)
 }
@@ -504,74 +475,45 @@ func (this *Routers) GetGroupMemberInfo(bot *BotYaml, params gjson.Result) Resul
 	var groupID int64 = params.Get("group_id").Int()
 	var userID int64 = params.Get("user_id").Int()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
 	if userID == 0 {
-		return makeError("无效'user_id'")
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
-	return makeOk(map[string]interface{}{
-		"group_id":          groupID,
-		"user_id":           userID,
-		"nickname":          core.GetNick(bot.Bot, userID),
-		"card":              core.GetNick(bot.Bot, userID),
-		"sex":               []string{"unknown", "male", "female"}[core.GetGender(bot.Bot, userID)],
-		"age":               core.GetAge(bot.Bot, userID),
-		"area":              "unknown",
-		"join_time":         0,
-		"last_sent_time":    0,
-		"level":             "unknown",
-		"role":              "unknown",
-		"unfriendly":        false,
-		"title":             "unknown",
-		"title_expire_time": 0,
-		"card_changeable":   true,
-	})
+	member, ok := bot.Cache.member(groupID, userID, params.Get("no_cache").Bool())
+	if !ok {
+		return makeError(ErrNotFound, "member not found")
+	}
+	info := xqMember2cq(member)
+	// 群成员列表中没有性别与年龄
+	info["sex"] = xq2cqSex(XQ.GetGender(bot.Bot, userID))
+	info["age"] = XQ.GetAge(bot.Bot, userID)
+	return makeOk(info)
 }
 
 func (this *Routers) GetGroupMemberList(bot *BotYaml, params gjson.Result) Result {
 	var groupID int64 = params.Get("group_id").Int()
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
-	list := core.GetGroupMemberList_C(
-		bot.Bot,
-		groupID,
-	)
-	if list == "" {
-		return makeError("获取群员列表失败")
+	members, ok := bot.Cache.memberList(groupID, params.Get("no_cache").Bool())
+	if !ok {
+		return makeError(ErrBackendFailure, "failed to get group member list")
 	}
-	g := gjson.Parse(list)
 	memberList := []map[string]interface{}{}
-	for _, o := range g.Get("list").Array() {
-		member := map[string]interface{}{
-			"group_id":          groupID,
-			"user_id":           o.Get("QQ").Int(),
-			"nickname":          "unknown",
-			"card":              "unknown",
-			"sex":               "unknown",
-			"age":               0,
-			"area":              "unknown",
-			"join_time":         0,
-			"last_sent_time":    0,
-			"level":             o.Get("lv").Int(),
-			"role":              "unknown",
-			"unfriendly":        false,
-			"title":             "unknown",
-			"title_expire_time": 0,
-			"card_changeable":   true,
-		}
-		memberList = append(memberList, member)
+	for _, m := range members {
+		memberList = append(memberList, xqMember2cq(m))
 	}
 	return makeOk(memberList)
 }
 
 func (this *Routers) GetGroupHonorInfo(bot *BotYaml, params gjson.Result) Result {
 	var groupID int64 = params.Get("group_id").Int()
-	var type_ string = params.Get("message_type").Str
+	var type_ string = params.Get("type").Str
 	if groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
-	cookie := fmt.Sprintf("%s%s", core.GetCookies(bot.Bot), core.GetGroupPsKey(bot.Bot))
+	cookie := fmt.Sprintf("%s%s", XQ.GetCookies(bot.Bot), XQ.GetGroupPsKey(bot.Bot))
 	var honorType int64 = 1
 	switch type_ {
 	case "talkative":
@@ -593,7 +535,7 @@ func (this *Routers) GetGroupHonorInfo(bot *BotYaml, params gjson.Result) Result
 		json.Unmarshal(data, &ret)
 		return makeOk(ret)
 	} else {
-		return makeError("error")
+		return makeError(ErrBackendFailure, "failed to get group honor info")
 	}
 }
 
@@ -601,36 +543,36 @@ func (this *Routers) GetCookies(bot *BotYaml, params gjson.Result) Result {
 	var domain string = params.Get("domain").Str
 	switch domain {
 	case "qun.qq.com":
-		return makeOk(map[string]interface{}{"cookies": core.GetCookies(bot.Bot) + core.GetGroupPsKey(bot.Bot)})
+		return makeOk(map[string]interface{}{"cookies": XQ.GetCookies(bot.Bot) + XQ.GetGroupPsKey(bot.Bot)})
 	case "qzone.qq.com":
-		return makeOk(map[string]interface{}{"cookies": core.GetCookies(bot.Bot) + core.GetZonePsKey(bot.Bot)})
+		return makeOk(map[string]interface{}{"cookies": XQ.GetCookies(bot.Bot) + XQ.GetZonePsKey(bot.Bot)})
 	default:
-		return makeOk(map[string]interface{}{"cookies": core.GetCookies(bot.Bot)})
+		return makeOk(map[string]interface{}{"cookies": XQ.GetCookies(bot.Bot)})
 	}
 }
 
 func (this *Routers) GetCsrfToken(bot *BotYaml, params gjson.Result) Result {
-	return makeError("暂未实现")
+	return makeError(ErrUnsupported, "not implemented")
 }
 
 func (this *Routers) GetCredentials(bot *BotYaml, params gjson.Result) Result {
 	var domain string = params.Get("domain").Str
 	switch domain {
 	case "qun.qq.com":
-		return makeOk(map[string]interface{}{"cookies": core.GetCookies(bot.Bot) + core.GetGroupPsKey(bot.Bot)})
+		return makeOk(map[string]interface{}{"cookies": XQ.GetCookies(bot.Bot) + XQ.GetGroupPsKey(bot.Bot)})
 	case "qzone.qq.com":
-		return makeOk(map[string]interface{}{"cookies": core.GetCookies(bot.Bot) + core.GetZonePsKey(bot.Bot)})
+		return makeOk(map[string]interface{}{"cookies": XQ.GetCookies(bot.Bot) + XQ.GetZonePsKey(bot.Bot)})
 	default:
-		return makeOk(map[string]interface{}{"cookies": core.GetCookies(bot.Bot)})
+		return makeOk(map[string]interface{}{"cookies": XQ.GetCookies(bot.Bot)})
 	}
 }
 
 func (this *Routers) GetRecord(bot *BotYaml, params gjson.Result) Result {
-	return makeError("暂未实现")
+	return makeError(ErrUnsupported, "not implemented")
 }
 
 func (this *Routers) GetImage(bot *BotYaml, params gjson.Result) Result {
-	return makeError("暂未实现")
+	return makeError(ErrUnsupported, "not implemented")
 }
 
 func (this *Routers) CanSendImage(bot *BotYaml, params gjson.Result) Result {
@@ -642,9 +584,25 @@ func (this *Routers) CanSendRecord(bot *BotYaml, params gjson.Result) Result {
 }
 
 func (this *Routers) GetStatus(bot *BotYaml, params gjson.Result) Result {
+	var dropped int64
+	endpoints := []map[string]interface{}{}
+	for _, s := range bot.WSSConf {
+		endpoints = append(endpoints, s.Outbox.Stat("websocket", s.Name))
+	}
+	for _, c := range bot.WSCConf {
+		endpoints = append(endpoints, c.Outbox.Stat("websocket_reverse", c.Name))
+	}
+	for _, h := range bot.HTTPConf {
+		endpoints = append(endpoints, h.Outbox.Stat("http", h.Name))
+	}
+	for _, e := range endpoints {
+		dropped += e["dropped"].(int64)
+	}
 	return makeOk(map[string]interface{}{
-		"online": core.IsOnline(bot.Bot, bot.Bot),
-		"good":   true,
+		"online":         XQ.IsOnline(bot.Bot, bot.Bot),
+		"good":           true,
+		"dropped_events": dropped,
+		"endpoints":      endpoints,
 	})
 }
 
@@ -657,16 +615,16 @@ func (this *Routers) GetVersionInfo(bot *BotYaml, params gjson.Result) Result {
 }
 
 func (this *Routers) SetRestart(bot *BotYaml, params gjson.Result) Result {
-	return makeError("暂未实现")
+	return makeError(ErrUnsupported, "not implemented")
 }
 
 func (this *Routers) CleanCache(bot *BotYaml, params gjson.Result) Result {
-	return makeError("暂未实现")
+	return makeError(ErrUnsupported, "not implemented")
 }
 
 func (this *Routers) OutPutLog(bot *BotYaml, params gjson.Result) Result {
 	var text string = params.Get("text").Str
-	core.OutPutLog(text)
+	XQ.OutPutLog(text)
 	return makeOk(nil)
 }
 
@@ -675,14 +633,14 @@ func (this *Routers) SendXml(bot *BotYaml, params gjson.Result) Result {
 	var userID int64 = params.Get("user_id").Int()
 	var type_ string = params.Get("message_type").Str
 	var data string = params.Get("data").Str
-	if groupID == 0 {
-		return makeError("无效'group_id'")
+	if type_ == "group" && groupID == 0 {
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
-	if userID == 0 {
-		return makeError("无效'user_id'")
+	if type_ == "private" && userID == 0 {
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
 	if groupID == 0 && userID == 0 {
-		return makeError("无效'group_id'或'user_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id' or 'user_id'")
 	}
 	if type_ == "" {
 		if groupID != 0 {
@@ -691,7 +649,7 @@ func (this *Routers) SendXml(bot *BotYaml, params gjson.Result) Result {
 			type_ = "private"
 		}
 	}
-	core.SendXML(
+	XQ.SendXML(
 		bot.Bot,
 		1,
 		cq2xqMsgType(type_),
@@ -708,14 +666,14 @@ func (this *Routers) SendJson(bot *BotYaml, params gjson.Result) Result {
 	var userID int64 = params.Get("user_id").Int()
 	var type_ string = params.Get("message_type").Str
 	var data string = params.Get("data").Str
-	if groupID == 0 {
-		return makeError("无效'group_id'")
+	if type_ == "group" && groupID == 0 {
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
-	if userID == 0 {
-		return makeError("无效'user_id'")
+	if type_ == "private" && userID == 0 {
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
 	if groupID == 0 && userID == 0 {
-		return makeError("无效'group_id'或'user_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id' or 'user_id'")
 	}
 	if type_ == "" {
 		if groupID != 0 {
@@ -724,7 +682,7 @@ func (this *Routers) SendJson(bot *BotYaml, params gjson.Result) Result {
 			type_ = "private"
 		}
 	}
-	core.SendJSON(
+	XQ.SendJSON(
 		bot.Bot,
 		1,
 		cq2xqMsgType(type_),
@@ -0,0 +1,50 @@
+package onebot
+
+import "testing"
+
+// newApiBot 只需要调用先驱接口的 API 不用数据库和上报
+func newApiBot(t *testing.T) *MemoryBackend {
+	backend := testBackend()
+	useBackend(t, backend)
+	useConf(t, testBotYaml(testBot))
+	return backend
+}
+
+func TestSetGroupCard(t *testing.T) {
+	backend := newApiBot(t)
+	ret := callApi(t, "set_group_card", `{"group_id":30001,"user_id":20002,"card":"新名片"}`)
+	if ret.Get("status").String() != "ok" {
+		t.Fatalf("set_group_card: %v", ret.Raw)
+	}
+	calls := backend.CallsOf("SetGroupCard")
+	if len(calls) != 1 || calls[0].Args["card"] != "新名片" {
+		t.Errorf("SetGroupCard calls = %v", calls)
+	}
+}
+
+func TestSendXmlTarget(t *testing.T) {
+	backend := newApiBot(t)
+	tests := []struct {
+		params string
+		ok     bool
+	}{
+		{`{"message_type":"private","user_id":20001,"data":"<msg/>"}`, true},
+		{`{"group_id":30001,"data":"<msg/>"}`, true},
+		{`{"message_type":"group","user_id":20001,"data":"<msg/>"}`, false},
+		{`{"data":"<msg/>"}`, false},
+	}
+	for _, action := range []string{"send_xml", "send_json"} {
+		for _, tt := range tests {
+			ret := callApi(t, action, tt.params)
+			if ok := ret.Get("status").String() == "ok"; ok != tt.ok {
+				t.Errorf("%v %v: %v", action, tt.params, ret.Raw)
+			}
+		}
+	}
+	if n := len(backend.CallsOf("SendXML")); n != 2 {
+		t.Errorf("SendXML called %d times", n)
+	}
+	if n := len(backend.CallsOf("SendJSON")); n != 2 {
+		t.Errorf("SendJSON called %d times", n)
+	}
+}
@@ -1,15 +1,12 @@
 package onebot
 
 import (
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/tidwall/gjson"
-
-	"yaya/core"
 )
 
 var Split bool
@@ -58,15 +55,14 @@ func (this *Routers) SendMsg(bot *BotYaml, params gjson.Result) Result {
 	var type_ string = params.Get("message_type").Str
 	var groupID int64 = params.Get("group_id").Int()
 	var userID int64 = params.Get("user_id").Int()
-	var message = params.Get("message")
 	if type_ == "group" && groupID == 0 {
-		return makeError("无效'group_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id'")
 	}
 	if type_ == "private" && userID == 0 {
-		return makeError("无效'user_id'")
+		return makeError(ErrBadRequest, "invalid 'user_id'")
 	}
 	if groupID == 0 && userID == 0 {
-		return makeError("无效'group_id'或'user_id'")
+		return makeError(ErrBadRequest, "invalid 'group_id' or 'user_id'")
 	}
 	if type_ == "" {
 		if groupID != 0 {
@@ -82,127 +78,81 @@ func (this *Routers) SendMsg(bot *BotYaml, params gjson.Result) Result {
 		UserID:  userID,
 	}
 
+	message := json2Message(params.Get("message"))
+	// auto_escape 只对字符串消息生效，整条消息作为纯文本发送
+	if params.Get("message").Type == gjson.String && params.Get("auto_escape").Bool() {
+		message = Message{textSegment(params.Get("message").Str)}
+	}
+	// 含有 node 的消息作为合并转发发送
+	if message.hasNode() {
+		return bot.sendForward(target, message)
+	}
+	return bot.sendMessage(target, message)
+}
+
+// sendMessage 把消息转为XQ码发送，返回 message_id
+func (bot *BotYaml) sendMessage(target msgTarget, message Message) Result {
 	var out string = ""
 	var bubble int64 = 0
-	var msg gjson.Result
-
-	if len(message.Get("#.type").Array()) == 0 {
-		b, _ := json.Marshal(cqCode2Array(message.Str))
-		msg = gjson.ParseBytes(b)
-	} else {
-		msg = message
-	}
+	var reply XMessage
 
-	for _, message := range msg.Array() {
-		switch message.Get("type").Str {
-		// 文字
-		case "text":
-			out += target.cq2xqText(message)
-		case "at":
-			out += target.cq2xqAt(message)
-		case "face":
-			out += target.cq2xqFace(message)
-		case "emoji":
-			out += target.cq2xqEmoji(message)
-		case "rps":
-			out += target.cq2xqRps(message)
-		case "dice":
-			out += target.cq2xqDice(message)
-		case "bubble":
-			bubble = message.Get("data.id").Int()
-		// 媒体
-		case "image":
-			out += target.cq2xqImage(message)
-		case "record":
-			out += target.cq2xqRecord(message)
-		case "video":
-			out += target.cq2xqVideo(message)
-		// 富文本
-		case "xml":
-			out += target.cq2xqXml(message)
-		case "json":
-			out += target.cq2xqJson(message)
-		case "share":
-			out += target.cq2xqShare(message)
-		case "music":
-			out += target.cq2xqMusic(message)
-		case "weather":
-			out += target.cq2xqWeather(message)
-		case "contact":
-			out += target.cq2xqContact(message)
-		case "location":
-			out += target.cq2xqLocation(message)
-		// 其他
-		case "shake":
-			out += target.cq2xqShake(message)
-		case "poke":
-			out += target.cq2xqPoke(message)
-		case "anonymous":
-			out += target.cq2xqAnonymous(message)
-		case "reply":
-			out += target.cq2xqReply(message)
-		case "forward":
-			out += target.cq2xqForward(message)
-		case "node":
-			out += target.cq2xqNode(message)
-		default:
-			out += target.cq2xqDefault(message)
+	for _, segment := range message {
+		if segment.Type == "bubble" {
+			bubble = Str2Int(segment.Data["id"])
+			continue
 		}
-	}
-	if out != "" {
-		// 如果开了分片就切割信息
-		if Conf.Cache.Video {
-			out = messageSplit(out)
-			// 调用core发送信息
-			for _, o := range strings.Split(out, "[Next]") {
-				core.SendMsgEX_V2(
-					target.BotID,
-					target.Type_,
-					target.GroupID,
-					target.UserID,
-					o,
-					bubble,
-					false,
-					"",
-				)
-				if strings.Contains(o, "[pic") {
-					time.Sleep(time.Millisecond * 1000)
-				} else {
-					time.Sleep(time.Millisecond * 200)
-				}
+		if segment.Type == "reply" {
+			reply = bot.findMsg(Str2Int(segment.Data["id"]))
+			if reply.ID == 0 {
+				WARN("[CQ码解析] %v 找不到回复的消息", segment.CQCode())
 			}
-			return makeOk(map[string]interface{}{"message_id": 0})
+			continue
 		}
-		data := core.SendMsgEX_V2(
-			target.BotID,
-			target.Type_,
-			target.GroupID,
-			target.UserID,
-			out,
-			bubble,
-			false,
-			"",
-		)
-		// 获取CQID返回
-		if data != "" {
-			ret := gjson.Parse(data[:strings.LastIndex(data, "}")])
-			if ret.Get("sendok").Bool() {
-				var xe XEvent
-				xe.MessageID = ret.Get("msgid").Int()
-				xe.MessageNum = ret.Get("msgno").Int()
-				xe.ID = 0
-				for i, _ := range Conf.BotConfs {
-					if bot.Bot == Conf.BotConfs[i].Bot && bot.Bot != 0 && Conf.BotConfs[i].DB != nil {
-						time.Sleep(time.Millisecond * 100)
-						Conf.BotConfs[i].dbSelect(&xe, "message_num="+core.Int2Str(xe.MessageNum))
-						return makeOk(map[string]interface{}{"message_id": xe.ID})
-					}
-				}
+		out += target.cq2xq(segment)
+	}
+	if out == "" {
+		return makeOk(map[string]interface{}{"message_id": 0})
+	}
+	// 如果开了分片就切割信息，返回第一段的 message_id
+	if Conf.Cache.Video {
+		var first int64 = 0
+		sent := false
+		for _, o := range strings.Split(messageSplit(out), "[Next]") {
+			// 只有第一段引用回复
+			id, ok := bot.saveSent(target, o, target.send(o, bubble, reply))
+			reply = XMessage{}
+			if ok && !sent {
+				first, sent = id, true
+			}
+			if strings.Contains(o, "[pic") {
+				time.Sleep(time.Millisecond * 1000)
+			} else {
+				time.Sleep(time.Millisecond * 200)
 			}
 		}
-		return makeError("可能受到风控")
+		if !sent {
+			return makeError(ErrMessageBlocked, "message may be blocked by risk control")
+		}
+		return makeOk(map[string]interface{}{"message_id": first})
+	}
+	id, ok := bot.saveSent(target, out, target.send(out, bubble, reply))
+	if !ok {
+		return makeError(ErrMessageBlocked, "message may be blocked by risk control")
+	}
+	return makeOk(map[string]interface{}{"message_id": id})
+}
+
+// send 发送XQ码消息，每条消息只发送一次
+// 默认在消息前引用原消息的文字，reply_json 开启时改用附加JSON参数引用回复，失败也不会重发
+func (target msgTarget) send(out string, bubble int64, reply XMessage) string {
+	switch {
+	case reply.ID == 0:
+		return XQ.SendMsgEX_V2(target.BotID, target.Type_, target.GroupID, target.UserID, out, bubble, false, "")
+	case Conf.ReplyJSON:
+		return XQ.SendMsgEX_V2(target.BotID, target.Type_, target.GroupID, target.UserID, out, bubble, false, xqReplyJSON(reply))
+	default:
+		return XQ.SendMsgEX_V2(target.BotID, target.Type_, target.GroupID, target.UserID, replyQuote(reply)+out, bubble, false, "")
 	}
-	return makeOk(map[string]interface{}{"message_id": 0})
 }
 
 func messageSplit(texts string) string {
@@ -229,23 +179,23 @@ func messageSplit(texts string) string {
 	return send
 }
 
-func (target msgTarget) cq2xqText(message gjson.Result) string {
-	return emoji2xq(message.Get("data.*").Str)
+func (target msgTarget) cq2xqText(message Segment) string {
+	return emoji2xq(xqEscape(message.Data["text"]))
 }
 
-func (target msgTarget) cq2xqFace(message gjson.Result) string {
-	return fmt.Sprintf("[Face%s.gif]", message.Get("data.*").Str)
+func (target msgTarget) cq2xqFace(message Segment) string {
+	return fmt.Sprintf("[Face%s.gif]", message.Data["id"])
 }
 
-func (target msgTarget) cq2xqAt(message gjson.Result) string {
-	return fmt.Sprintf("[@%s] ", message.Get("data.*").Str)
+func (target msgTarget) cq2xqAt(message Segment) string {
+	return fmt.Sprintf("[@%s] ", message.Data["qq"])
 }
 
-func (target msgTarget) cq2xqEmoji(message gjson.Result) string {
-	return fmt.Sprintf("[emoji=%s]", message.Get("data.*").Str)
+func (target msgTarget) cq2xqEmoji(message Segment) string {
+	return fmt.Sprintf("[emoji=%s]", message.Data["id"])
 }
 
-func (target msgTarget) cq2xqRps(message gjson.Result) string {
+func (target msgTarget) cq2xqRps(message Segment) string {
 	return []string{
 		"[魔法猜拳] 石头",
 		"[魔法猜拳] 剪刀",
@@ -253,7 +203,7 @@ func (target msgTarget) cq2xqRps(message gjson.Result) string {
 	}[rand.Intn(3)]
 }
 
-func (target msgTarget) cq2xqDice(message gjson.Result) string {
+func (target msgTarget) cq2xqDice(message Segment) string {
 	return []string{
 		"[魔法骰子] 1",
 		"[魔法骰子] 2",
@@ -264,11 +214,11 @@ func (target msgTarget) cq2xqDice(message gjson.Result) string {
 	}[rand.Intn(6)]
 }
 
-func (target msgTarget) cq2xqImage(message gjson.Result) string {
-	url := strings.ReplaceAll(message.Get("data.url").Str, `\/`, `/`)
-	image := strings.ReplaceAll(message.Get("data.file").Str, `\/`, `/`)
-	showID := message.Get("data.id").Int() - 40000
-	switch message.Get("data.type").Str {
+func (target msgTarget) cq2xqImage(message Segment) string {
+	url := strings.ReplaceAll(message.Data["url"], `\/`, `/`)
+	image := strings.ReplaceAll(message.Data["file"], `\/`, `/`)
+	showID := Str2Int(message.Data["id"]) - 40000
+	switch message.Data["type"] {
 	case "show":
 		switch {
 		case url != "":
@@ -315,8 +265,8 @@ func (target msgTarget) cq2xqImage(message gjson.Result) string {
 	}
 }
 
-func (target msgTarget) cq2xqRecord(message gjson.Result) string {
-	record := strings.ReplaceAll(message.Get("data.file").Str, `\/`, `/`)
+func (target msgTarget) cq2xqRecord(message Segment) string {
+	record := strings.ReplaceAll(message.Data["file"], `\/`, `/`)
 	switch {
 	case strings.Contains(record, "base64://"):
 		return fmt.Sprintf("[Voi=%s]", rec2silk(Base642Record(record[9:])))
@@ -331,14 +281,14 @@ func (target msgTarget) cq2xqRecord(message gjson.Result) string {
 	}
 }
 
-func (target msgTarget) cq2xqVideo(message gjson.Result) string {
-	DEBUG("[CQ码解析] %v 不支持", message.Str)
-	core.SendMsgEX_V2(
+func (target msgTarget) cq2xqVideo(message Segment) string {
+	DEBUG("[CQ码解析] %v 不支持", message.CQCode())
+	XQ.SendMsgEX_V2(
 		target.BotID,
 		target.Type_,
 		target.GroupID,
 		target.UserID,
-		message.Get("data.*").Str,
+		message.Data["file"],
 		0,
 		false,
 		" ",
@@ -346,10 +296,10 @@ func (target msgTarget) cq2xqVideo(message gjson.Result) string {
 	return ""
 }
 
-func (target msgTarget) cq2xqMusic(message gjson.Result) string {
+func (target msgTarget) cq2xqMusic(message Segment) string {
 	switch {
-	case message.Get("data.type").Str == "custom":
-		core.SendXML(
+	case message.Data["type"] == "custom":
+		XQ.SendXML(
 			target.BotID,
 			1,
 			target.Type_,
@@ -364,25 +314,25 @@ func (target msgTarget) cq2xqMusic(message gjson.Result) string {
 				url="http://web.p.qq.com/qqmpmobile/aio/app.html?id=1101079856" 
 				action="app" a_actionData="com.tencent.qqmusic" 
 				i_actionData="tencent1101079856://" appid="1101079856" /></msg>`,
-				XmlEscape(message.Get("data.title").Str),
-				message.Get("data.url").Str,
-				message.Get("data.image").Str,
-				message.Get("data.audio").Str,
-				XmlEscape(message.Get("data.title").Str),
-				XmlEscape(message.Get("data.content").Str),
+				XmlEscape(message.Data["title"]),
+				message.Data["url"],
+				message.Data["image"],
+				message.Data["audio"],
+				XmlEscape(message.Data["title"]),
+				XmlEscape(message.Data["content"]),
 			),
 			0,
 		)
 	default:
-		DEBUG("[CQ码解析] %v 暂未实现", message.Str)
-		core.SendMsgEX_V2(
+		DEBUG("[CQ码解析] %v 暂未实现", message.CQCode())
+		XQ.SendMsgEX_V2(
 			target.BotID,
 			target.Type_,
 			target.GroupID,
 			target.UserID,
 			fmt.Sprintf("音乐分享：%s %s",
-				message.Get("data.type").Str,
-				message.Get("data.id").Str,
+				message.Data["type"],
+				message.Data["id"],
 			),
 			0,
 			false,
@@ -392,8 +342,8 @@ func (target msgTarget) cq2xqMusic(message gjson.Result) string {
 	return ""
 }
 
-func (target msgTarget) cq2xqWeather(message gjson.Result) string {
-	core.SendJSON(
+func (target msgTarget) cq2xqWeather(message Segment) string {
+	XQ.SendJSON(
 		target.BotID,
 		1,
 		target.Type_,
@@ -405,44 +355,44 @@ func (target msgTarget) cq2xqWeather(message gjson.Result) string {
 			"sourceUrl":"","meta":{"richinfo":{"adcode":"","air":"%s",
 			"city":"%s","date":"%s","max":"%s","min":"%s",
 			"ts":"15158613","type":"%s","wind":""}},"text":"","sourceAd":"","extra":""}`,
-			message.Get("data.air").Str,
-			message.Get("data.city").Str,
-			message.Get("data.date").Str,
-			message.Get("data.max").Str,
-			message.Get("data.min").Str,
-			message.Get("data.type").Str,
+			message.Data["air"],
+			message.Data["city"],
+			message.Data["date"],
+			message.Data["max"],
+			message.Data["min"],
+			message.Data["type"],
 		),
 	)
 	return ""
 }
 
-func (target msgTarget) cq2xqXml(message gjson.Result) string {
-	core.SendXML(
+func (target msgTarget) cq2xqXml(message Segment) string {
+	XQ.SendXML(
 		target.BotID,
 		1,
 		target.Type_,
 		target.GroupID,
 		target.UserID,
-		message.Get("data.*").Str,
+		message.Data["data"],
 		0,
 	)
 	return ""
 }
 
-func (target msgTarget) cq2xqJson(message gjson.Result) string {
-	core.SendJSON(
+func (target msgTarget) cq2xqJson(message Segment) string {
+	XQ.SendJSON(
 		target.BotID,
 		1,
 		target.Type_,
 		target.GroupID,
 		target.UserID,
-		message.Get("data.*").Str,
+		message.Data["data"],
 	)
 	return ""
 }
 
-func (target msgTarget) cq2xqShare(message gjson.Result) string {
-	core.SendXML(
+func (target msgTarget) cq2xqShare(message Segment) string {
+	XQ.SendXML(
 		target.BotID,
 		1,
 		target.Type_,
@@ -455,21 +405,21 @@ func (target msgTarget) cq2xqShare(message gjson.Result) string {
 				advertiser_id="0" aid="0"><picture cover="%s" w="0" h="0" />
 				<title>%s</title><summary>%s</summary>
 				</item><source name="" icon="" action="" appid="-1" /></msg>`,
-			message.Get("data.brief").Str,
-			message.Get("data.url").Str,
-			message.Get("data.image").Str,
-			message.Get("data.title").Str,
-			message.Get("data.content").Str,
+			message.Data["brief"],
+			message.Data["url"],
+			message.Data["image"],
+			message.Data["title"],
+			message.Data["content"],
 		),
 		0,
 	)
 	return ""
 }
 
-func (target msgTarget) cq2xqContact(message gjson.Result) string {
-	switch message.Get("data.type").Str {
+func (target msgTarget) cq2xqContact(message Segment) string {
+	switch message.Data["type"] {
 	case "qq":
-		core.SendXML(
+		XQ.SendXML(
 			target.BotID,
 			1,
 			target.Type_,
@@ -491,18 +441,18 @@ func (target msgTarget) cq2xqContact(message gjson.Result) string {
 			source=sharecard&amp;version=1&amp;uin=%s" w="0" h="0" />
 			<title>%s</title><summary>帐号:%s</summary>
 			</item><source name="" icon="" action="" appid="-1" /></msg>`,
-				message.Get("data.id").Str,
-				message.Get("data.id").Str,
-				message.Get("data.id").Str,
-				message.Get("data.name").Str,
-				message.Get("data.id").Str,
-				message.Get("data.name").Str,
-				message.Get("data.id").Str,
+				message.Data["id"],
+				message.Data["id"],
+				message.Data["id"],
+				message.Data["name"],
+				message.Data["id"],
+				message.Data["name"],
+				message.Data["id"],
 			),
 			0,
 		)
 	case "group":
-		core.SendXML(
+		XQ.SendXML(
 			target.BotID,
 			1,
 			target.Type_,
@@ -519,15 +469,15 @@ func (target msgTarget) cq2xqContact(message gjson.Result) string {
 				<picture cover="https://p.qlogo.cn/gh/%s/%s/100" w="0" h="0" needRoundView="0" />
 				<title>%s</title><summary>创建人：%s</summary></item>
 				<source name="" icon="" action="" appid="-1" /></msg>`,
-				message.Get("data.id").Str,
-				message.Get("data.id").Str,
-				message.Get("data.id").Str,
-				message.Get("data.name").Str,
-				message.Get("data.url").Str,
-				message.Get("data.id").Str,
-				message.Get("data.id").Str,
-				message.Get("data.name").Str,
-				message.Get("data.owner").Str,
+				message.Data["id"],
+				message.Data["id"],
+				message.Data["id"],
+				message.Data["name"],
+				message.Data["url"],
+				message.Data["id"],
+				message.Data["id"],
+				message.Data["name"],
+				message.Data["owner"],
 			),
 			0,
 		)
@@ -535,8 +485,8 @@ func (target msgTarget) cq2xqContact(message gjson.Result) string {
 	return ""
 }
 
-func (target msgTarget) cq2xqLocation(message gjson.Result) string {
-	core.SendJSON(
+func (target msgTarget) cq2xqLocation(message Segment) string {
+	XQ.SendJSON(
 		target.BotID,
 		1,
 		target.Type_,
@@ -548,26 +498,26 @@ func (target msgTarget) cq2xqLocation(message gjson.Result) string {
 			"lng":%s,"lat":%s,"zoom":15,"locName":"%s"}},
 			"config":{"forward":true,"autosize":1},"text":"","extraApps":[],
 			"sourceAd":"","extra":""}`,
-			message.Get("data.content").Str,
-			message.Get("data.lon").Str,
-			message.Get("data.lat").Str,
-			message.Get("data.title").Str,
+			message.Data["content"],
+			message.Data["lon"],
+			message.Data["lat"],
+			message.Data["title"],
 		),
 	)
 	return ""
 }
 
-func (target msgTarget) cq2xqShake(message gjson.Result) string {
-	core.ShakeWindow(
+func (target msgTarget) cq2xqShake(message Segment) string {
+	XQ.ShakeWindow(
 		target.BotID,
 		target.UserID,
 	)
 	return ""
 }
 
-func (target msgTarget) cq2xqPoke(message gjson.Result) string {
-	DEBUG("[CQ码解析] %v 不支持", message.Str)
-	core.SendMsgEX_V2(
+func (target msgTarget) cq2xqPoke(message Segment) string {
+	DEBUG("[CQ码解析] %v 不支持", message.CQCode())
+	XQ.SendMsgEX_V2(
 		target.BotID,
 		target.Type_,
 		target.GroupID,
@@ -584,13 +534,17 @@ func (target msgTarget) cq2xqPoke(message gjson.Result) string {
 	return ""
 }
 
-func (target msgTarget) cq2xqAnonymous(message gjson.Result) string {
-	core.SendMsgEX_V2(
+// cq2xqAnonymous 把 text 匿名发送
+func (target msgTarget) cq2xqAnonymous(message Segment) string {
+	if message.Data["text"] == "" {
+		return ""
+	}
+	XQ.SendMsgEX_V2(
 		target.BotID,
 		target.Type_,
 		target.GroupID,
 		target.UserID,
-		message.Get("data.*").Str,
+		message.Data["text"],
 		0,
 		true,
 		" ",
@@ -598,27 +552,9 @@ func (target msgTarget) cq2xqAnonymous(message gjson.Result) string {
 	return ""
 }
 
-func (target msgTarget) cq2xqReply(message gjson.Result) string {
-	DEBUG("[CQ码解析] %v 不支持", message.Str)
-	core.SendMsgEX_V2(
-		target.BotID,
-		target.Type_,
-		target.GroupID,
-		target.UserID,
-		fmt.Sprintf(
-			"[系统消息] %v 尝试回复一条消息并失败了",
-			target.BotID,
-		),
-		0,
-		false,
-		" ",
-	)
-	return ""
-}
-
-func (target msgTarget) cq2xqForward(message gjson.Result) string {
-	DEBUG("[CQ码解析] %v 不支持", message.Str)
-	core.SendMsgEX_V2(
+func (target msgTarget) cq2xqForward(message Segment) string {
+	DEBUG("[CQ码解析] %v 不支持", message.CQCode())
+	XQ.SendMsgEX_V2(
 		target.BotID,
 		target.Type_,
 		target.GroupID,
@@ -634,32 +570,18 @@ func (target msgTarget) cq2xqForward(message gjson.Result) string {
 	return ""
 }
 
-func (target msgTarget) cq2xqNode(message gjson.Result) string {
-	DEBUG("[CQ码解析] %v 不支持", message.Str)
-	core.SendMsgEX_V2(
-		target.BotID,
-		target.Type_,
-		target.GroupID,
-		target.UserID,
-		fmt.Sprintf(
-			"[系统消息] %v 尝试合并转发节点并失败了",
-			target.BotID,
-		),
-		0,
-		false,
-		" ",
-	)
-	return ""
-}
-
-func (target msgTarget) cq2xqDefault(message gjson.Result) string {
-	DEBUG("[CQ码解析] %v 不支持", message.Str)
-	core.SendMsgEX_V2(
+// cq2xqDefault 不认识的消息段只发送其中的 text，没有时什么都不发
+func (target msgTarget) cq2xqDefault(message Segment) string {
+	DEBUG("[CQ码解析] %v 不支持", message.CQCode())
+	if message.Data["text"] == "" {
+		return ""
+	}
+	XQ.SendMsgEX_V2(
 		target.BotID,
 		target.Type_,
 		target.GroupID,
 		target.UserID,
-		message.Get("data.*").Str,
+		message.Data["text"],
 		0,
 		false,
 		" ",
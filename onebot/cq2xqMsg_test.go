@@ -0,0 +1,76 @@
+package onebot
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// anonymous 与不认识的消息段发送 text，而不是按字母排第一个的参数
+func TestSendSegmentText(t *testing.T) {
+	backend := testBackend()
+	bot := testBotYaml(testBot)
+	useBackend(t, backend)
+	useConf(t, bot)
+	openTestDB(t, bot)
+	tests := []struct {
+		message   string
+		want      string
+		anonymous bool
+	}{
+		{"[CQ:anonymous,ignore=1,text=悄悄话]", "悄悄话", true},
+		{"[CQ:unknown,a=1,text=hi]", "hi", false},
+	}
+	for _, tt := range tests {
+		ret := callApi(t, "send_msg", `{"message_type":"group","group_id":30001,"message":"`+tt.message+`"}`)
+		if ret.Get("status").String() != "ok" {
+			t.Errorf("%v: %v", tt.message, ret.Raw)
+		}
+		found := false
+		for _, call := range backend.CallsOf("SendMsgEX_V2") {
+			found = found || call.Args["message"] == tt.want && call.Args["anonymous"] == tt.anonymous
+		}
+		if !found {
+			t.Errorf("%v: SendMsgEX_V2 calls = %v", tt.message, backend.CallsOf("SendMsgEX_V2"))
+		}
+	}
+
+	// 没有 text 时不发送空消息
+	n := len(backend.CallsOf("SendMsgEX_V2"))
+	callApi(t, "send_msg", `{"message_type":"group","group_id":30001,"message":"[CQ:unknown,a=1]"}`)
+	for _, call := range backend.CallsOf("SendMsgEX_V2")[n:] {
+		if call.Args["message"] == "1" {
+			t.Errorf("sent parameter a: %v", call.Args)
+		}
+	}
+}
+
+// 引用回复只发送一次，reply_json 决定使用附加JSON参数还是引用文字
+func TestSendReply(t *testing.T) {
+	backend := testBackend()
+	bot := testBotYaml(testBot)
+	useBackend(t, backend)
+	conf := useConf(t, bot)
+	openTestDB(t, bot)
+
+	id := callApi(t, "send_msg", `{"message_type":"group","group_id":30001,"message":"原消息"}`).Get("data.message_id").Int()
+	for _, replyJSON := range []bool{false, true} {
+		conf.ReplyJSON = replyJSON
+		n := len(backend.CallsOf("SendMsgEX_V2"))
+		ret := callApi(t, "send_msg", `{"message_type":"group","group_id":30001,"message":"[CQ:reply,id=`+Int2Str(id)+`]回复"}`)
+		if ret.Get("status").String() != "ok" {
+			t.Fatalf("reply_json %v: %v", replyJSON, ret.Raw)
+		}
+		calls := backend.CallsOf("SendMsgEX_V2")[n:]
+		if len(calls) != 1 {
+			t.Fatalf("reply_json %v: SendMsgEX_V2 called %d times", replyJSON, len(calls))
+		}
+		message, jsonData := calls[0].Args["message"].(string), calls[0].Args["json_data"].(string)
+		if replyJSON && (message != "回复" || gjson.Get(jsonData, "Reply.Content").String() != "原消息") {
+			t.Errorf("reply_json true: message = %q, json_data = %q", message, jsonData)
+		}
+		if !replyJSON && (message != "「10001: 原消息」\n回复" || jsonData != "") {
+			t.Errorf("reply_json false: message = %q, json_data = %q", message, jsonData)
+		}
+	}
+}
@@ -19,18 +19,26 @@ func (conf *Yaml) runOnebot() {
 		for j, _ := range conf.BotConfs[i].WSSConf {
 			if conf.BotConfs[i].WSSConf[j].Status == 0 && conf.BotConfs[i].WSSConf[j].Enable == true && conf.BotConfs[i].WSSConf[j].Host != "" {
 				go conf.BotConfs[i].WSSConf[j].start()
-				go conf.BotConfs[i].WSSConf[j].listen()
 				go conf.BotConfs[i].WSSConf[j].send()
 			}
 		}
 		for k, _ := range conf.BotConfs[i].WSCConf {
-			if conf.BotConfs[i].WSCConf[k].Status == 0 && conf.BotConfs[i].WSCConf[k].Enable == true && conf.BotConfs[i].WSCConf[k].Url != "" {
-				go conf.BotConfs[i].WSCConf[k].listen()
-				go conf.BotConfs[i].WSCConf[k].send()
+			if conf.BotConfs[i].WSCConf[k].Status == 0 && conf.BotConfs[i].WSCConf[k].Enable == true {
+				conf.BotConfs[i].WSCConf[k].Queue = openSpool(conf.BotConfs[i].Bot, "wsc", k, conf.BotConfs[i].WSCConf[k].Spool)
+				if conf.BotConfs[i].WSCConf[k].eventUrl() != "" {
+					go conf.BotConfs[i].WSCConf[k].listen()
+					go conf.BotConfs[i].WSCConf[k].send()
+				}
+				// 分离模式下 API 连接单独维护
+				if !conf.BotConfs[i].WSCConf[k].UseUniversalClient && conf.BotConfs[i].WSCConf[k].ApiUrl != "" {
+					go conf.BotConfs[i].WSCConf[k].listenApi()
+					go conf.BotConfs[i].WSCConf[k].sendApi()
+				}
 			}
 		}
 		for l, _ := range conf.BotConfs[i].HTTPConf {
 			if conf.BotConfs[i].HTTPConf[l].Status == 0 && conf.BotConfs[i].HTTPConf[l].Enable == true {
+				conf.BotConfs[i].HTTPConf[l].Queue = openSpool(conf.BotConfs[i].Bot, "http", l, conf.BotConfs[i].HTTPConf[l].Spool)
 				if conf.BotConfs[i].HTTPConf[l].Host != "" {
 					go conf.BotConfs[i].HTTPConf[l].listen()
 				}
@@ -60,12 +68,12 @@ func (conf *Yaml) heartBeat() {
 		if conf.HeratBeatConf.Enable && conf.HeratBeatConf.Interval != 0 {
 			for i, _ := range conf.BotConfs {
 				for j, _ := range conf.BotConfs[i].WSSConf {
-					if conf.BotConfs[i].WSSConf[j].Status == 1 && conf.BotConfs[i].WSSConf[j].Enable {
+					if conf.BotConfs[i].WSSConf[j].online() && conf.BotConfs[i].WSSConf[j].Enable {
 						conf.BotConfs[i].WSSConf[j].Heart <- heartEvent(conf.HeratBeatConf.Interval, conf.BotConfs[i].Bot)
 					}
 				}
 				for k, _ := range conf.BotConfs[i].WSCConf {
-					if conf.BotConfs[i].WSCConf[k].Status == 1 && conf.BotConfs[i].WSCConf[k].Enable {
+					if conf.BotConfs[i].WSCConf[k].online() && conf.BotConfs[i].WSCConf[k].Enable {
 						conf.BotConfs[i].WSCConf[k].Heart <- heartEvent(conf.HeratBeatConf.Interval, conf.BotConfs[i].Bot)
 					}
 				}
@@ -4,12 +4,11 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
 
-	"yaya/core"
-
 	"database/sql"
 
 	"github.com/gorilla/websocket"
@@ -24,6 +23,8 @@ type Yaml struct {
 	Meta          bool           `yaml:"-"`
 	HeratBeatConf *HeratBeatYaml `yaml:"heratbeat"`
 	Cache         *CacheYaml     `yaml:"cache"`
+	Forward       string         `yaml:"forward"`
+	ReplyJSON     bool           `yaml:"reply_json"`
 	BotConfs      []*BotYaml     `yaml:"bots"`
 }
 
@@ -32,6 +33,8 @@ type CacheYaml struct {
 	Image    bool `yaml:"image"`
 	Record   bool `yaml:"record"`
 	Video    bool `yaml:"video"`
+	// MemberTTL 群成员缓存的有效期，单位秒
+	MemberTTL int64 `yaml:"member_ttl"`
 }
 
 type HeratBeatYaml struct {
@@ -43,6 +46,7 @@ type BotYaml struct {
 	Bot      int64       `yaml:"bot"`
 	DB       *sql.DB     `yaml:"-"`
 	DBPath   string      `yaml:"-"`
+	Cache    *botCache   `yaml:"-"`
 	WSSConf  []*WSSYaml  `yaml:"websocket"`
 	WSCConf  []*WSCYaml  `yaml:"websocket_reverse"`
 	HTTPConf []*HTTPYaml `yaml:"http"`
@@ -57,11 +61,17 @@ type HTTPYaml struct {
 	PostUrl           string      `yaml:"post_url"`
 	Secret            string      `yaml:"secret"`
 	TimeOut           int64       `yaml:"time_out"`
+	RetryTimes        int64       `yaml:"retry_times"`
+	RetryInterval     int64       `yaml:"retry_interval"`
 	PostMessageFormat string      `yaml:"post_message_format"`
+	EventPolicy       string      `yaml:"event_policy"`
+	Spool             SpoolYaml   `yaml:"spool"`
 	BotID             int64       `yaml:"-"`
 	Status            int64       `yaml:"-"`
 	Event             chan []byte `yaml:"-"`
 	Heart             chan []byte `yaml:"-"`
+	Queue             *Spool      `yaml:"-"`
+	Outbox            *Outbox     `yaml:"-"`
 }
 
 type WSCYaml struct {
@@ -74,25 +84,37 @@ type WSCYaml struct {
 	AccessToken        string          `yaml:"access_token"`
 	PostMessageFormat  string          `yaml:"post_message_format"`
 	ReconnectInterval  int64           `yaml:"reconnect_interval"`
+	EventPolicy        string          `yaml:"event_policy"`
+	Spool              SpoolYaml       `yaml:"spool"`
 	BotID              int64           `yaml:"-"`
 	Status             int64           `yaml:"-"`
 	Conn               *websocket.Conn `yaml:"-"`
+	ApiConn            *websocket.Conn `yaml:"-"`
 	Event              chan []byte     `yaml:"-"`
 	Heart              chan []byte     `yaml:"-"`
+	Reply              chan []byte     `yaml:"-"`
+	Queue              *Spool          `yaml:"-"`
+	Outbox             *Outbox         `yaml:"-"`
+
+	// lock 保护 Conn ApiConn Status，listen、listenApi、send 与事件推送在不同的协程中访问
+	lock sync.Mutex
 }
 
 type WSSYaml struct {
-	Name              string            `yaml:"name"`
-	Enable            bool              `yaml:"enable"`
-	Host              string            `yaml:"host"`
-	Port              int64             `yaml:"port"`
-	AccessToken       string            `yaml:"access_token"`
-	PostMessageFormat string            `yaml:"post_message_format"`
-	BotID             int64             `yaml:"-"`
-	Status            int64             `yaml:"-"`
-	Conn              []*websocket.Conn `yaml:"-"`
-	Event             chan []byte       `yaml:"-"`
-	Heart             chan []byte       `yaml:"-"`
+	Name              string              `yaml:"name"`
+	Enable            bool                `yaml:"enable"`
+	Host              string              `yaml:"host"`
+	Port              int64               `yaml:"port"`
+	AccessToken       string              `yaml:"access_token"`
+	PostMessageFormat string              `yaml:"post_message_format"`
+	EventPolicy       string              `yaml:"event_policy"`
+	BotID             int64               `yaml:"-"`
+	Status            int64               `yaml:"-"`
+	Clients           map[*wssClient]bool `yaml:"-"`
+	Event             chan []byte         `yaml:"-"`
+	Heart             chan []byte         `yaml:"-"`
+	Outbox            *Outbox             `yaml:"-"`
+	lock              sync.Mutex
 }
 
 func (conf *Yaml) getBotConfig(bot int64) *BotYaml {
@@ -110,11 +132,14 @@ func DefaultConfig() *Yaml {
 		Master:  12345678,
 		Debug:   true,
 		Cache: &CacheYaml{
-			DataBase: false,
-			Image:    false,
-			Record:   false,
-			Video:    false,
+			DataBase:  false,
+			Image:     false,
+			Record:    false,
+			Video:     false,
+			MemberTTL: defaultMemberTTL,
 		},
+		Forward:   "messages",
+		ReplyJSON: false,
 		HeratBeatConf: &HeratBeatYaml{
 			Enable:   true,
 			Interval: 10000,
@@ -124,11 +149,8 @@ func DefaultConfig() *Yaml {
 }
 
 func DefaultQQ() int64 {
-	botList := strings.Split(core.GetQQList(), "/n")
-	if len(botList) < 0 {
-		return 0
-	}
-	return core.Str2Int(botList[0])
+	botList := strings.Split(XQ.GetQQList(), "\n")
+	return Str2Int(strings.TrimSpace(botList[0]))
 }
 
 func DefaultBotConfig() *BotYaml {
@@ -142,6 +164,7 @@ func DefaultBotConfig() *BotYaml {
 				Port:              6700,
 				AccessToken:       "",
 				PostMessageFormat: "string",
+				EventPolicy:       "block",
 			},
 		},
 		WSCConf: []*WSCYaml{
@@ -155,6 +178,13 @@ func DefaultBotConfig() *BotYaml {
 				AccessToken:        "",
 				PostMessageFormat:  "string",
 				ReconnectInterval:  3000,
+				EventPolicy:        "block",
+				Spool: SpoolYaml{
+					Enable:     false,
+					MaxAge:     86400,
+					MaxSize:    10000,
+					DropPolicy: "drop_oldest",
+				},
 			},
 		},
 		HTTPConf: []*HTTPYaml{
@@ -166,8 +196,17 @@ func DefaultBotConfig() *BotYaml {
 				AccessToken:       "",
 				PostUrl:           "http://127.0.0.1/plugin",
 				Secret:            "",
-				TimeOut:           0,
+				TimeOut:           defaultPostTimeout,
+				RetryTimes:        3,
+				RetryInterval:     1000,
 				PostMessageFormat: "string",
+				EventPolicy:       "block",
+				Spool: SpoolYaml{
+					Enable:     false,
+					MaxAge:     86400,
+					MaxSize:    10000,
+					DropPolicy: "drop_oldest",
+				},
 			},
 		},
 	}
@@ -215,23 +254,36 @@ func (conf *Yaml) InitConf() {
 			conf.BotConfs[i].Bot = DefaultQQ()
 			conf.Save(AppPath + "config.yml")
 		}
+		conf.BotConfs[i].Cache = newBotCache(conf.BotConfs[i])
 		for j, _ := range conf.BotConfs[i].WSSConf {
 			conf.BotConfs[i].WSSConf[j].Status = 0
 			conf.BotConfs[i].WSSConf[j].BotID = conf.BotConfs[i].Bot
 			conf.BotConfs[i].WSSConf[j].Event = make(chan []byte, 100)
 			conf.BotConfs[i].WSSConf[j].Heart = make(chan []byte, 1)
+			conf.BotConfs[i].WSSConf[j].Clients = map[*wssClient]bool{}
+			wss := conf.BotConfs[i].WSSConf[j]
+			wss.Outbox = NewOutbox(
+				"[正向WS]["+Int2Str(wss.BotID)+"] "+wss.Name,
+				wss.EventPolicy,
+				func(send []byte) { wss.Event <- send },
+			)
 		}
 		for k, _ := range conf.BotConfs[i].WSCConf {
 			conf.BotConfs[i].WSCConf[k].Status = 0
 			conf.BotConfs[i].WSCConf[k].BotID = conf.BotConfs[i].Bot
 			conf.BotConfs[i].WSCConf[k].Event = make(chan []byte, 100)
 			conf.BotConfs[i].WSCConf[k].Heart = make(chan []byte, 1)
+			conf.BotConfs[i].WSCConf[k].Reply = make(chan []byte, 100)
+			wsc := conf.BotConfs[i].WSCConf[k]
+			wsc.Outbox = NewOutbox("[反向WS]["+Int2Str(wsc.BotID)+"] "+wsc.Name, wsc.EventPolicy, wsc.push)
 		}
 		for l, _ := range conf.BotConfs[i].HTTPConf {
 			conf.BotConfs[i].HTTPConf[l].Status = 0
 			conf.BotConfs[i].HTTPConf[l].BotID = conf.BotConfs[i].Bot
 			conf.BotConfs[i].HTTPConf[l].Event = make(chan []byte, 100)
 			conf.BotConfs[i].HTTPConf[l].Heart = make(chan []byte, 1)
+			h := conf.BotConfs[i].HTTPConf[l]
+			h.Outbox = NewOutbox("[HTTP]["+Int2Str(h.BotID)+"] "+h.Name, h.EventPolicy, h.push)
 		}
 	}
 }
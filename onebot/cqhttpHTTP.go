@@ -8,7 +8,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strconv"
 	"strings"
@@ -17,6 +19,9 @@ import (
 	"github.com/tidwall/gjson"
 )
 
+// defaultPostTimeout time_out 未设置时上报等待响应的时间，单位秒
+const defaultPostTimeout = 10
+
 func (h *HTTPYaml) listen() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -35,26 +40,102 @@ func (h *HTTPYaml) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if err := recover(); err != nil {
 			ERROR("[监听][HTTP][%v] BOT =X=> ==> %v:%v Error: %v", h.BotID, h.Host, h.Port, err)
+			writeFailed(w, err)
 		}
 	}()
 
-	if r.Header.Get("Authorization") == h.AccessToken || h.AccessToken == "" {
-		if r.Header.Get("Content-Type") == "application/json" {
+	if h.AccessToken != "" {
+		token := httpToken(r)
+		if token == "" {
+			WARN("[监听][HTTP][%v] BOT X Token X %v:%v", h.BotID, h.Host, h.Port)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if token != h.AccessToken {
+			WARN("[监听][HTTP][%v] BOT X Token X %v:%v", h.BotID, h.Host, h.Port)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	action := strings.Trim(r.URL.Path, "/")
+	// 给 SDK 用的能力查询，与 get_supported_actions 内容相同
+	if action == "_capabilities" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		data, _ := json.Marshal(capabilities())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(data)
+		return
+	}
+	if !apiMap.Has(actionName(action)) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var data []byte
+	switch r.Method {
+	case http.MethodGet:
+		data = form2json(r.URL.Query())
+	case http.MethodPost:
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/json":
 			buf := new(bytes.Buffer)
 			buf.ReadFrom(r.Body)
-			w.Write(h.apiReply(r.URL.Path, buf.Bytes()))
-		} else {
+			data = buf.Bytes()
+		case "application/x-www-form-urlencoded", "":
 			r.ParseForm()
-			dataMap := make(map[string]interface{})
-			for k, v := range r.Form {
-				dataMap[k] = v[0]
-			}
-			data, _ := json.Marshal(dataMap)
-			w.Write(h.apiReply(r.URL.Path, data))
+			data = form2json(r.Form)
+		default:
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	code, send := h.apiReply(action, data)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(send)
+}
+
+// writeFailed 处理请求时 panic，返回 500 与 BACKEND_FAILURE
+func writeFailed(w http.ResponseWriter, err interface{}) {
+	send, _ := json.Marshal(makeError(ErrBackendFailure, "%v", err))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(send)
+}
+
+// httpToken 从 Authorization 头或 access_token 参数中取出 Token
+func httpToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	for _, prefix := range []string{"Bearer ", "Token "} {
+		if strings.HasPrefix(auth, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(auth, prefix))
 		}
-	} else {
-		WARN("[监听][HTTP][%v] BOT X Secret X %v:%v", h.BotID, h.Host, h.Port)
 	}
+	if auth != "" {
+		return auth
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// form2json 表单或查询参数转为 JSON，同名参数只取第一个
+func form2json(form url.Values) []byte {
+	dataMap := make(map[string]interface{})
+	for k, v := range form {
+		if k == "access_token" {
+			continue
+		}
+		dataMap[k] = v[0]
+	}
+	data, _ := json.Marshal(dataMap)
+	return data
 }
 
 func (h *HTTPYaml) send() {
@@ -66,79 +147,155 @@ func (h *HTTPYaml) send() {
 			h.send()
 		}
 	}()
+	if h.TimeOut <= 0 {
+		INFO("[上报][HTTP][%v] TimeOut %v -> %v", h.BotID, h.TimeOut, defaultPostTimeout)
+		h.TimeOut = defaultPostTimeout
+	}
 	client := &http.Client{
+		Timeout: time.Duration(h.TimeOut) * time.Second,
 		Transport: &http.Transport{
 			DisableKeepAlives: true,
 		},
 	}
 	INFO("[上报][HTTP][%v] BOT ==> ==> %v", h.BotID, h.PostUrl)
 	h.Status = 1
+	// 落盘队列补发失败后定时重试
+	retry := time.NewTicker(time.Second * 10)
+	defer retry.Stop()
+	h.flush(client)
 	for {
 		select {
 		case send := <-h.Event:
 			if h.PostUrl != "" {
-				req, _ := http.NewRequest("POST", h.PostUrl, bytes.NewBuffer(send))
-				req.Header.Set("Content-Type", "application/json")
-				req.Header.Set("X-Self-ID", strconv.FormatInt(h.BotID, 10))
-				req.Header.Set("User-Agent", "CQHttp/4.15.0")
-				if h.Secret != "" {
-					mac := hmac.New(sha1.New, []byte(h.Secret))
-					mac.Write(send)
-					req.Header.Set("X-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+				code, body := h.post(client, send, h.RetryTimes)
+				if code == 0 || code >= 500 {
+					// 没有落盘队列，重试后仍然失败的事件只能丢弃
+					h.Outbox.fail()
+					continue
 				}
-				resp, err := client.Do(req)
-				if err != nil {
-					panic(err)
-				} else {
-					DEBUG("[上报][HTTP][%v] %v <- %v", h.BotID, h.PostUrl, string(send))
-					body, _ := ioutil.ReadAll(resp.Body)
-					if string(body) != "" {
-						h.fastReply(send, body)
-					}
-				}
-				resp.Body.Close()
+				h.onPosted(send, code, body)
 			}
+		case <-h.Queue.Ready():
+			h.flush(client)
+		case <-retry.C:
+			h.flush(client)
 		case send := <-h.Heart:
 			if h.PostUrl != "" {
-				req, _ := http.NewRequest("POST", h.PostUrl, bytes.NewBuffer(send))
-				req.Header.Set("Content-Type", "application/json")
-				req.Header.Set("X-Self-ID", strconv.FormatInt(h.BotID, 10))
-				req.Header.Set("User-Agent", "CQHttp/4.15.0")
-				if h.Secret != "" {
-					mac := hmac.New(sha1.New, []byte(h.Secret))
-					mac.Write(send)
-					req.Header.Set("X-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
-				}
-				resp, err := client.Do(req)
-				if err != nil {
-					panic(err)
+				if code, _ := h.post(client, send, 0); code != 0 {
+					META("[心跳][HTTP][%v] %v <- %v", h.BotID, h.PostUrl, string(send))
 				}
-				META("[心跳][HTTP][%v] %v <- %v", h.BotID, h.PostUrl, string(send))
-				resp.Body.Close()
 			}
 		}
 	}
 }
 
-func (h *HTTPYaml) apiReply(path string, data []byte) []byte {
+// push 启用了落盘队列时写入队列，否则直接放入发送通道
+func (h *HTTPYaml) push(send []byte) {
+	if h.Queue != nil {
+		h.Queue.Push(send)
+		return
+	}
+	h.Event <- send
+}
+
+// flush 按顺序上报落盘队列中的事件，上报失败时保留在队列中等待下次重试
+func (h *HTTPYaml) flush(client *http.Client) {
+	if h.Queue == nil || h.PostUrl == "" {
+		return
+	}
+	for {
+		id, send, ok := h.Queue.Peek()
+		if !ok {
+			return
+		}
+		code, body := h.post(client, send, h.RetryTimes)
+		if code == 0 || code >= 500 {
+			return
+		}
+		h.Queue.Ack(id)
+		h.onPosted(send, code, body)
+	}
+}
+
+// onPosted 上报成功后处理响应
+func (h *HTTPYaml) onPosted(send []byte, code int, body []byte) {
+	DEBUG("[上报][HTTP][%v] %v <- %v", h.BotID, h.PostUrl, string(send))
+	// 只有 200 且为 JSON 对象的响应才是快速操作，204 等其他 2xx 不做处理
+	if code == http.StatusOK && gjson.ValidBytes(body) && gjson.ParseBytes(body).IsObject() {
+		h.fastReply(send, body)
+	}
+}
+
+// post 上报数据，网络错误或 5xx 时按 retry_interval 翻倍间隔重试 retry 次
+// 返回最后一次的状态码与响应，全部失败时状态码为 0
+func (h *HTTPYaml) post(client *http.Client, send []byte, retry int64) (int, []byte) {
+	interval := time.Duration(h.RetryInterval) * time.Millisecond
+	for i := int64(0); ; i++ {
+		code, body, err := h.postOnce(client, send)
+		switch {
+		case err != nil:
+			ERROR("[上报][HTTP][%v] BOT =X=> ==> %v Error: %v", h.BotID, h.PostUrl, err)
+		case code < 200 || code > 299:
+			WARN("[上报][HTTP][%v] BOT ==> =X=> %v Status: %v Body: %v", h.BotID, h.PostUrl, code, string(body))
+		}
+		if (err == nil && code < 500) || i >= retry {
+			if err != nil || code >= 500 {
+				WARN("[上报][HTTP][%v] BOT =X=> ==> %v 重试 %v 次后放弃: %v", h.BotID, h.PostUrl, i, string(send))
+			}
+			return code, body
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+func (h *HTTPYaml) postOnce(client *http.Client, send []byte) (int, []byte, error) {
+	req, err := http.NewRequest("POST", h.PostUrl, bytes.NewBuffer(send))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Self-ID", strconv.FormatInt(h.BotID, 10))
+	req.Header.Set("User-Agent", "CQHttp/4.15.0")
+	if h.Secret != "" {
+		mac := hmac.New(sha1.New, []byte(h.Secret))
+		mac.Write(send)
+		req.Header.Set("X-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// apiReply 调用API，返回 HTTP 状态码与响应，API panic 时为 500 与 BACKEND_FAILURE
+func (h *HTTPYaml) apiReply(action string, data []byte) (code int, send []byte) {
 	defer func() {
 		if err := recover(); err != nil {
 			ERROR("[响应][HTTP][%v] BOT X %v:%v Error: %v", h.BotID, h.Host, h.Port, err)
 			buf := make([]byte, 1<<16)
 			runtime.Stack(buf, true)
 			ERROR("traceback:\n%v", string(buf))
+			code = http.StatusInternalServerError
+			send, _ = json.Marshal(makeError(ErrBackendFailure, "%v", err))
 		}
 	}()
 
-	action := strings.ReplaceAll(path, "/", "")
 	DEBUG("[响应][HTTP][%v] BOT <- %v:%v API: %v Params: %v", h.BotID, h.Host, h.Port, action, string(data))
 
 	params := gjson.ParseBytes(data)
-	ret := apiMap.CallApi(action, h.BotID, params)
-	send, _ := json.Marshal(ret)
-	return send
+	ret := apiMap.Dispatch(action, h.BotID, params)
+	send, _ = json.Marshal(ret)
+	return http.StatusOK, send
 }
 
+// fastReply 上报响应中的快速操作，与 .handle_quick_operation 相同
 func (h *HTTPYaml) fastReply(send []byte, reply []byte) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -147,105 +304,8 @@ func (h *HTTPYaml) fastReply(send []byte, reply []byte) {
 	}()
 	DEBUG("[快速回复][HTTP][%v] BOT <- %v:%v API: %v", h.BotID, h.Host, h.Port, string(reply))
 
-	context := gjson.ParseBytes(send)
-	operation := gjson.ParseBytes(reply)
-
-	switch context.Get("path post_type").Str {
-	case "message":
-		switch {
-		case operation.Get("reply").Exists():
-			var text string
-			if operation.Get("at_sender").Bool() {
-				text += fmt.Sprintf("[CQ:at,qq=%s]", context.Get("user_id").Str)
-			}
-			text += unicode2chinese(operation.Get("reply").Str)
-			data, _ := json.Marshal(
-				map[string]interface{}{
-					"message_type": context.Get("message_type").Str,
-					"group_id":     context.Get("group_id").Int(),
-					"user_id":      context.Get("user_id").Int(),
-					"message":      text,
-				},
-			)
-			apiMap.CallApi(
-				"send_msg",
-				h.BotID,
-				gjson.ParseBytes(data),
-			)
-			return
-		case operation.Get("delete").Bool():
-			data, _ := json.Marshal(
-				map[string]interface{}{
-					"message_id": context.Get("message_id").Int(),
-				},
-			)
-			apiMap.CallApi(
-				"delete_msg",
-				h.BotID,
-				gjson.ParseBytes(data),
-			)
-			return
-		case operation.Get("kick").Bool():
-			data, _ := json.Marshal(
-				map[string]interface{}{
-					"group_id":           context.Get("group_id").Int(),
-					"user_id":            context.Get("user_id").Int(),
-					"reject_add_request": false,
-				},
-			)
-			apiMap.CallApi(
-				"set_group_kick",
-				h.BotID,
-				gjson.ParseBytes(data),
-			)
-			return
-		case operation.Get("ban").Bool():
-			data, _ := json.Marshal(
-				map[string]interface{}{
-					"group_id": context.Get("group_id").Int(),
-					"user_id":  context.Get("user_id").Int(),
-					"duration": context.Get("duration").Int(),
-				},
-			)
-			apiMap.CallApi(
-				"set_group_ban",
-				h.BotID,
-				gjson.ParseBytes(data),
-			)
-			return
-		}
-	case "request":
-		if operation.Get("approve").Exists() {
-			switch {
-			case operation.Get("request_type").Str == "friend":
-				data, _ := json.Marshal(
-					map[string]interface{}{
-						"flag":    context.Get("flag").Str,
-						"approve": context.Get("approve").Bool(),
-						"remark":  context.Get("remark").Str,
-					},
-				)
-				apiMap.CallApi(
-					"set_friend_add_request",
-					h.BotID,
-					gjson.ParseBytes(data),
-				)
-				return
-			case operation.Get("request_type").Str == "group":
-				data, _ := json.Marshal(
-					map[string]interface{}{
-						"flag":    context.Get("flag").Str,
-						"approve": context.Get("approve").Bool(),
-						"reason":  context.Get("reason").Str,
-					},
-				)
-				apiMap.CallApi(
-					"set_group_add_request",
-					h.BotID,
-					gjson.ParseBytes(data),
-				)
-				return
-			}
-		}
+	ret := quickOperation(h.BotID, gjson.ParseBytes(send), gjson.ParseBytes(reply))
+	if ret.Status == "failed" {
+		WARN("[快速回复][HTTP][%v] BOT X %v:%v Retcode: %v Wording: %v", h.BotID, h.Host, h.Port, ret.Retcode, ret.Wording)
 	}
 }
@@ -0,0 +1,38 @@
+package onebot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestHTTPApiPanic(t *testing.T) {
+	useBackend(t, testBackend())
+	useConf(t, testBotYaml(testBot))
+	useActions(t, Action{
+		Name: "test_panic",
+		Handler: func(this *Routers, bot *BotYaml, params gjson.Result) Result {
+			panic("boom")
+		},
+	})
+	h := &HTTPYaml{BotID: testBot}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test_panic", strings.NewReader("{}")))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %v", w.Code)
+	}
+	ret := gjson.Parse(w.Body.String())
+	if ret.Get("status").String() != "failed" || ret.Get("retcode").Int() != ErrBackendFailure.Retcode {
+		t.Errorf("body = %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get_login_info", nil))
+	if w.Code != http.StatusOK || gjson.Get(w.Body.String(), "data.user_id").Int() != testBot {
+		t.Errorf("get_login_info: %v %v", w.Code, w.Body.String())
+	}
+}
@@ -5,21 +5,20 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/hjson/hjson-go"
 	"github.com/tidwall/gjson"
 )
 
-func (c *WSCYaml) connect() {
+// connect 以指定的 X-Client-Role 连接到 url，失败时按重连间隔一直重试
+func (c *WSCYaml) connect(role string, url string) *websocket.Conn {
 	if c.ReconnectInterval < 1000 {
 		INFO("[连接][反向WS][%v] ReconnectInterval %v -> 1000", c.BotID, c.ReconnectInterval)
 		c.ReconnectInterval = 1000
 	}
 	header := http.Header{
-		"X-Client-Role": []string{"Universal"},
+		"X-Client-Role": []string{role},
 		"X-Self-ID":     []string{strconv.FormatInt(c.BotID, 10)},
 		"User-Agent":    []string{"CQHttp/4.15.0"},
 	}
@@ -27,20 +26,69 @@ func (c *WSCYaml) connect() {
 		header["Authorization"] = []string{"Token " + c.AccessToken}
 	}
 	for {
-		conn, _, err := websocket.DefaultDialer.Dial(c.Url, header)
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
 		if err != nil {
-			DEBUG("[连接][反向WS][%v] BOT =X=> ==> %v ", c.BotID, c.Url)
+			DEBUG("[连接][反向WS][%v] BOT =X=> ==> %v ", c.BotID, url)
 			time.Sleep(time.Millisecond * time.Duration(c.ReconnectInterval))
 			continue
-		} else {
-			c.Conn = conn
-			c.Status = 1
-			c.handShake()
-			INFO("[连接][反向WS][%v] BOT ==> ==> %v ", c.BotID, c.Url)
-			break
 		}
+		INFO("[连接][反向WS][%v][%v] BOT ==> ==> %v ", c.BotID, role, url)
+		return conn
 	}
+}
+
+// eventUrl 上报事件使用的地址，Universal 模式为 url，分离模式为 event_url
+func (c *WSCYaml) eventUrl() string {
+	if c.UseUniversalClient {
+		return c.Url
+	}
+	return c.EventUrl
+}
+
+// apiUrl 处理API调用使用的地址，Universal 模式为 url，分离模式为 api_url
+func (c *WSCYaml) apiUrl() string {
+	if c.UseUniversalClient {
+		return c.Url
+	}
+	return c.ApiUrl
+}
+
+// setConn 更新 Universal 或 Event 连接，conn 为 nil 时为断开
+func (c *WSCYaml) setConn(conn *websocket.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Conn = conn
+	if conn != nil {
+		c.Status = 1
+	} else {
+		c.Status = 0
+	}
+}
+
+func (c *WSCYaml) conn() *websocket.Conn {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.Conn
+}
+
+// setApiConn 更新分离模式下的 API 连接，conn 为 nil 时为断开
+func (c *WSCYaml) setApiConn(conn *websocket.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ApiConn = conn
+}
+
+func (c *WSCYaml) apiConn() *websocket.Conn {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ApiConn
+}
 
+// online Universal 或 Event 连接是否已经连上
+func (c *WSCYaml) online() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.Status == 1
 }
 
 func (c *WSCYaml) handShake() {
@@ -51,92 +99,185 @@ func (c *WSCYaml) handShake() {
 		"sub_type":        "connect",
 		"time":            fmt.Sprint(time.Now().Unix()),
 	}
-	event, _ := hjson.Marshal(handshake)
-	if c.Status == 1 {
+	event, _ := json.Marshal(handshake)
+	if c.online() {
 		c.Heart <- event
 	}
 }
 
+// listen 维护 Universal 或 Event 连接，断开后重新连接，Universal 模式下同时处理API调用
 func (c *WSCYaml) listen() {
+	role := "Universal"
+	if !c.UseUniversalClient {
+		role = "Event"
+	}
+	for {
+		conn := c.connect(role, c.eventUrl())
+		c.setConn(conn)
+		c.handShake()
+		INFO("[监听][反向WS][%v] BOT ==> ==> %v ", c.BotID, c.eventUrl())
+		// Event 连接只负责上报，收到的内容直接忽略
+		err := c.read(conn, c.UseUniversalClient)
+		c.setConn(nil)
+		WARN("[监听][反向WS][%v] BOT =X=> ==> %v ERROR: %v", c.BotID, c.eventUrl(), err)
+	}
+}
+
+// listenApi 分离模式下维护 API 连接并处理API调用，与 Event 连接互不影响
+func (c *WSCYaml) listenApi() {
+	for {
+		conn := c.connect("API", c.ApiUrl)
+		c.setApiConn(conn)
+		INFO("[监听][反向WS][%v] BOT ==> ==> %v ", c.BotID, c.ApiUrl)
+		err := c.read(conn, true)
+		c.setApiConn(nil)
+		WARN("[监听][反向WS][%v] BOT =X=> ==> %v ERROR: %v", c.BotID, c.ApiUrl, err)
+	}
+}
+
+// read 读取连接直到断开并关闭连接，api 为 true 时处理收到的API调用
+func (c *WSCYaml) read(conn *websocket.Conn, api bool) (err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			c.Status = 0
-			WARN("[监听][反向WS][%v] BOT =X=> ==> %v ERROR: %v", c.BotID, c.Url, err)
-			c.listen()
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
 		}
+		conn.Close()
 	}()
-	c.connect()
-	// TODO 等待wsc连接成功
 	for {
-		if c.Status == 1 {
-			break
+		_, buf, readErr := conn.ReadMessage()
+		if readErr != nil {
+			return readErr
 		}
-		time.Sleep(time.Second * 1)
-	}
-	// TODO 监听wsc
-	INFO("[监听][反向WS][%v] BOT ==> ==> %v ", c.BotID, c.Url)
-	for {
-		_, buf, err := c.Conn.ReadMessage()
-		if err != nil {
-			panic(err)
+		if api {
+			go c.apiReply(buf)
 		}
-		go c.apiReply(buf)
 	}
 }
 
 func (c *WSCYaml) send() {
 	defer func() {
 		if err := recover(); err != nil {
-			WARN("[上报][反向WS][%v] BOT =X=> ==> %v ERROR: %v", c.BotID, c.Url, err)
+			WARN("[上报][反向WS][%v] BOT =X=> ==> %v ERROR: %v", c.BotID, c.eventUrl(), err)
+			time.Sleep(time.Millisecond * time.Duration(c.ReconnectInterval))
 			c.send()
 		}
 	}()
 	// TODO 等待wsc连接成功
-	for {
-		if c.Status == 1 {
-			break
-		}
+	for !c.online() {
 		time.Sleep(time.Second * 1)
 	}
-	INFO("[上报][反向WS][%v] BOT ==> ==> %v ", c.BotID, c.Url)
+	INFO("[上报][反向WS][%v] BOT ==> ==> %v ", c.BotID, c.eventUrl())
+	// 先补发断线期间落盘的事件
+	c.flush()
 	for {
+		// 通用连接上API响应优先于事件
+		select {
+		case send := <-c.universalReply():
+			c.write(send)
+			DEBUG("[响应][反向WS][%v] %v <- %v", c.BotID, c.eventUrl(), string(send))
+			continue
+		default:
+		}
 		select {
+		case send := <-c.universalReply():
+			c.write(send)
+			DEBUG("[响应][反向WS][%v] %v <- %v", c.BotID, c.eventUrl(), string(send))
 		case send := <-c.Event:
-			_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
-			err := c.Conn.WriteMessage(websocket.TextMessage, send)
-			if err != nil {
-				panic(err)
-			} else {
-				DEBUG("[上报][反向WS][%v] %v <- %v", c.BotID, c.Url, string(send))
-			}
+			c.write(send)
+			DEBUG("[上报][反向WS][%v] %v <- %v", c.BotID, c.eventUrl(), string(send))
 		case send := <-c.Heart:
-			_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
-			err := c.Conn.WriteMessage(websocket.TextMessage, send)
-			if err != nil {
-				panic(err)
-			} else {
-				META("[心跳][反向WS][%v] %v <- %v", c.BotID, c.Url, string(send))
-			}
+			c.write(send)
+			META("[心跳][反向WS][%v] %v <- %v", c.BotID, c.eventUrl(), string(send))
+		case <-c.Queue.Ready():
+			c.flush()
 		}
 	}
 }
 
+// universalReply 通用连接模式下API响应与事件共用一个连接，分离模式下由 sendApi 负责
+func (c *WSCYaml) universalReply() chan []byte {
+	if c.UseUniversalClient {
+		return c.Reply
+	}
+	return nil
+}
+
+// write 写入 Universal 或 Event 连接，失败时 panic 由 send 重启
+func (c *WSCYaml) write(send []byte) {
+	conn := c.conn()
+	if conn == nil {
+		panic("not connected")
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+	if err := conn.WriteMessage(websocket.TextMessage, send); err != nil {
+		panic(err)
+	}
+}
+
+// push 启用了落盘队列时写入队列，否则直接放入发送通道
+func (c *WSCYaml) push(send []byte) {
+	if c.Queue != nil {
+		c.Queue.Push(send)
+		return
+	}
+	c.Event <- send
+}
+
+// flush 按顺序发送落盘队列中的事件，写入成功后才从队列删除
+func (c *WSCYaml) flush() {
+	if c.Queue == nil {
+		return
+	}
+	// 刚连上时的握手事件要先于补发的事件
+	select {
+	case send := <-c.Heart:
+		c.write(send)
+		META("[心跳][反向WS][%v] %v <- %v", c.BotID, c.eventUrl(), string(send))
+	default:
+	}
+	for {
+		id, send, ok := c.Queue.Peek()
+		if !ok {
+			return
+		}
+		c.write(send)
+		c.Queue.Ack(id)
+		DEBUG("[上报][反向WS][%v] %v <- %v", c.BotID, c.eventUrl(), string(send))
+	}
+}
+
+// sendApi 分离模式下把API调用结果写回 API 连接
+func (c *WSCYaml) sendApi() {
+	for send := range c.Reply {
+		conn := c.apiConn()
+		if conn == nil {
+			WARN("[响应][反向WS][%v] BOT =X=> ==> %v 连接已断开，丢弃响应", c.BotID, c.ApiUrl)
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+		if err := conn.WriteMessage(websocket.TextMessage, send); err != nil {
+			WARN("[响应][反向WS][%v] BOT =X=> ==> %v ERROR: %v", c.BotID, c.ApiUrl, err)
+			continue
+		}
+		DEBUG("[响应][反向WS][%v] %v <- %v", c.BotID, c.ApiUrl, string(send))
+	}
+}
+
 func (c *WSCYaml) apiReply(data []byte) {
 	defer func() {
 		if err := recover(); err != nil {
-			ERROR("[响应][反向WS][%v] BOT X %v Error: %v", c.BotID, c.Url, err)
+			ERROR("[响应][反向WS][%v] BOT X %v Error: %v", c.BotID, c.apiUrl(), err)
 		}
 	}()
 
 	obj := gjson.ParseBytes(data)
 
 	action := obj.Get("action").Str
-	action = strings.ReplaceAll(action, "_async", "")
 	params := obj.Get("params")
-	DEBUG("[响应][反向WS][%v] BOT <- %v API: %v Params: %v", c.BotID, c.Url, action, string(data))
+	DEBUG("[响应][反向WS][%v] BOT <- %v API: %v Params: %v", c.BotID, c.apiUrl(), action, string(data))
 
-	ret := apiMap.CallApi(action, c.BotID, params)
+	ret := apiMap.Dispatch(action, c.BotID, params)
 	ret.Echo = obj.Get("echo").Value()
 	send, _ := json.Marshal(ret)
-	c.Event <- send
+	c.Reply <- send
 }
@@ -8,12 +8,26 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/hjson/hjson-go"
 	"github.com/tidwall/gjson"
 )
 
 var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 
+// wssQueueSize 每个客户端待发送队列的长度，满了以后新的事件会被丢弃
+const wssQueueSize = 100
+
+// wssClient 正向WS的一个客户端，读写各占一个协程，互不阻塞
+type wssClient struct {
+	conn  *websocket.Conn
+	addr  string
+	role  string
+	api   bool // 是否处理API调用
+	event bool // 是否推送事件
+	queue chan []byte
+	reply chan []byte // API响应单独排队，优先于事件发送
+	done  chan struct{}
+}
+
 func (s *WSSYaml) start() {
 	http.ListenAndServe(fmt.Sprintf("%v:%v", s.Host, s.Port), s)
 }
@@ -24,20 +38,76 @@ func (s *WSSYaml) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			ERROR("[连接][正向WS][%v] BOT =X=> ==> %v:%v Error: %v", s.BotID, s.Host, s.Port, err)
 		}
 	}()
+	// / 为通用连接，/api 只处理API调用，/event 只推送事件
+	client := &wssClient{}
+	switch strings.TrimRight(r.URL.Path, "/") {
+	case "":
+		client.role, client.api, client.event = "Universal", true, true
+	case "/api":
+		client.role, client.api = "API", true
+	case "/event":
+		client.role, client.event = "Event", true
+	default:
+		http.NotFound(w, r)
+		return
+	}
 	if r.Header.Get("Authorization") == "Token "+s.AccessToken || s.AccessToken == "" {
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			panic(err)
 		}
-		s.Conn = append(s.Conn, conn)
-		s.Status = 1
-		s.handShake()
+		client.conn = conn
+		client.addr = conn.RemoteAddr().String()
+		client.queue = make(chan []byte, wssQueueSize)
+		client.reply = make(chan []byte, wssQueueSize)
+		client.done = make(chan struct{})
+		s.register(client)
+		go s.write(client)
+		if client.event {
+			s.handShake(client)
+		}
+		s.listen(client)
 	} else {
 		ERROR("[连接][正向WS][%v] BOT X Token X %v:%v", s.BotID, s.Host, s.Port)
 	}
 }
 
-func (s *WSSYaml) handShake() {
+// register 登记新的客户端
+func (s *WSSYaml) register(client *wssClient) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.Clients == nil {
+		s.Clients = map[*wssClient]bool{}
+	}
+	s.Clients[client] = true
+	s.Status = 1
+	INFO("[连接][正向WS][%v][%v] BOT <== <== %v 当前连接数 %v", s.BotID, client.role, client.addr, len(s.Clients))
+}
+
+// unregister 移除断开的客户端，不影响其他客户端
+func (s *WSSYaml) unregister(client *wssClient) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.Clients[client] {
+		return
+	}
+	delete(s.Clients, client)
+	close(client.done)
+	client.conn.Close()
+	if len(s.Clients) < 1 {
+		s.Status = 0
+	}
+	WARN("[连接][正向WS][%v][%v] BOT =X= =X= %v 当前连接数 %v", s.BotID, client.role, client.addr, len(s.Clients))
+}
+
+// online 是否有客户端连接，Status 由 register 与 unregister 在锁内更新
+func (s *WSSYaml) online() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.Status == 1
+}
+
+func (s *WSSYaml) handShake(client *wssClient) {
 	handshake := map[string]string{
 		"meta_event_type": "lifecycle",
 		"post_type":       "meta_event",
@@ -45,38 +115,80 @@ func (s *WSSYaml) handShake() {
 		"sub_type":        "connect",
 		"time":            fmt.Sprint(time.Now().Unix()),
 	}
-	event, _ := hjson.Marshal(handshake)
-	if s.Status == 1 {
-		s.Event <- event
-	}
+	event, _ := json.Marshal(handshake)
+	client.push(event)
 }
 
-func (s *WSSYaml) listen() {
-	defer func() {
-		if err := recover(); err != nil {
-			ERROR("[监听][正向WS][%v] BOT =X=> ==> %v:%v Error: %v", s.BotID, s.Host, s.Port, err)
-			s.Status = 0
-			s.listen()
-		}
-	}()
+// listen 读取一个客户端的API调用，直到连接断开，Event 连接收到的数据会被忽略
+func (s *WSSYaml) listen(client *wssClient) {
+	defer s.unregister(client)
+	INFO("[监听][正向WS][%v][%v] BOT ==> ==> %v", s.BotID, client.role, client.addr)
 	for {
-		if s.Status == 1 {
-			break
+		_, buf, err := client.conn.ReadMessage()
+		if err != nil {
+			ERROR("[监听][正向WS][%v] BOT =X=> ==> %v Error: %v", s.BotID, client.addr, err)
+			return
+		}
+		if !client.api {
+			continue
 		}
-		time.Sleep(time.Second * 1)
+		go s.apiReply(client, buf)
 	}
-	INFO("[监听][正向WS][%v] BOT ==> ==> %v:%v", s.BotID, s.Host, s.Port)
+}
+
+// write 把一个客户端队列中的数据写入连接，写失败时断开该客户端
+func (s *WSSYaml) write(client *wssClient) {
 	for {
-		for i, conn := range s.Conn {
-			_, buf, err := conn.ReadMessage()
-			if err != nil {
-				s.Conn = append(s.Conn[:i], s.Conn[i+1:]...)
-				if len(s.Conn) < 1 {
-					s.Status = 0
-				}
-				panic(err)
+		var send []byte
+		// API响应不排在事件后面
+		select {
+		case send = <-client.reply:
+		default:
+			select {
+			case send = <-client.reply:
+			case send = <-client.queue:
+			case <-client.done:
+				return
 			}
-			go s.apiReply(buf)
+		}
+		_ = client.conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
+		if err := client.conn.WriteMessage(websocket.TextMessage, send); err != nil {
+			ERROR("[上报][正向WS][%v] BOT =X=> ==> %v Error: %v", s.BotID, client.addr, err)
+			s.unregister(client)
+			return
+		}
+	}
+}
+
+// push 非阻塞地放入客户端队列，队列满时丢弃
+func (client *wssClient) push(send []byte) bool {
+	select {
+	case client.queue <- send:
+		return true
+	default:
+		return false
+	}
+}
+
+// respond 把API响应放入客户端的响应队列，客户端断开前会一直等待
+func (client *wssClient) respond(send []byte) {
+	select {
+	case client.reply <- send:
+	case <-client.done:
+	}
+}
+
+// broadcast 把数据分发给当前所有接收事件的客户端，客户端队列满时丢弃的也计入 Outbox 的 dropped
+func (s *WSSYaml) broadcast(send []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for client := range s.Clients {
+		if !client.event {
+			continue
+		}
+		if !client.push(send) {
+			s.Outbox.drop()
+			WARN("[上报][正向WS][%v] BOT =X=> ==> %v 队列已满，丢弃一条上报", s.BotID, client.addr)
 		}
 	}
 }
@@ -85,66 +197,36 @@ func (s *WSSYaml) send() {
 	defer func() {
 		if err := recover(); err != nil {
 			ERROR("[上报][正向WS][%v] BOT =X=> ==> %v:%v Error: %v", s.BotID, s.Host, s.Port, err)
-			s.Status = 0
 			s.send()
 		}
 	}()
-	for {
-		if s.Status == 1 {
-			break
-		}
-		time.Sleep(time.Second * 1)
-	}
 	INFO("[上报][正向WS][%v] BOT ==> ==> %v:%v", s.BotID, s.Host, s.Port)
 	for {
 		select {
 		case send := <-s.Event:
-			for i, conn := range s.Conn {
-				_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
-				err := conn.WriteMessage(websocket.TextMessage, send)
-				if err != nil {
-					s.Conn = append(s.Conn[:i], s.Conn[i+1:]...)
-					if len(s.Conn) < 1 {
-						s.Status = 0
-					}
-					panic(err)
-				} else {
-					DEBUG("[上报][正向WS][%v] %v:%v <- %v", s.BotID, s.Host, s.Port, string(send))
-				}
-			}
+			s.broadcast(send)
+			DEBUG("[上报][正向WS][%v] %v:%v <- %v", s.BotID, s.Host, s.Port, string(send))
 		case send := <-s.Heart:
-			for i, conn := range s.Conn {
-				_ = conn.SetWriteDeadline(time.Now().Add(time.Second * 15))
-				err := conn.WriteMessage(websocket.TextMessage, send)
-				if err != nil {
-					s.Conn = append(s.Conn[:i], s.Conn[i+1:]...)
-					if len(s.Conn) < 1 {
-						s.Status = 0
-					}
-					panic(err)
-				} else {
-					META("[心跳][正向WS][%v] %v:%v <- %v", s.BotID, s.Host, s.Port, string(send))
-				}
-			}
+			s.broadcast(send)
+			META("[心跳][正向WS][%v] %v:%v <- %v", s.BotID, s.Host, s.Port, string(send))
 		}
 	}
 }
 
-func (s *WSSYaml) apiReply(data []byte) {
+func (s *WSSYaml) apiReply(client *wssClient, data []byte) {
 	defer func() {
 		if err := recover(); err != nil {
-			ERROR("[响应][正向WS][%v] BOT X %v:%v Error: %v", s.BotID, s.Host, s.Port, err)
+			ERROR("[响应][正向WS][%v] BOT X %v Error: %v", s.BotID, client.addr, err)
 		}
 	}()
 	obj := gjson.ParseBytes(data)
 
 	action := obj.Get("action").Str
-	action = strings.ReplaceAll(action, "_async", "")
 	params := obj.Get("params")
-	DEBUG("[响应][正向WS][%v] BOT <- %v:%v API: %v Params: %v", s.BotID, s.Host, s.Port, action, string(data))
+	DEBUG("[响应][正向WS][%v] BOT <- %v API: %v Params: %v", s.BotID, client.addr, action, string(data))
 
-	ret := apiMap.CallApi(action, s.BotID, params)
+	ret := apiMap.Dispatch(action, s.BotID, params)
 	ret.Echo = obj.Get("echo").Value()
 	send, _ := json.Marshal(ret)
-	s.Event <- send
+	client.respond(send)
 }
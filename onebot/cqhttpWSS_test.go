@@ -0,0 +1,124 @@
+package onebot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+)
+
+// newTestWSS 启动一个正向WS服务端，测试结束时断开所有客户端
+func newTestWSS(t *testing.T) (*WSSYaml, string) {
+	useBackend(t, testBackend())
+	useConf(t, testBotYaml(testBot))
+	s := &WSSYaml{BotID: testBot}
+	server := httptest.NewServer(s)
+	t.Cleanup(func() {
+		s.lock.Lock()
+		for client := range s.Clients {
+			client.conn.Close()
+		}
+		s.lock.Unlock()
+		// 等 unregister 写完日志再还原全局变量
+		for s.online() {
+			time.Sleep(time.Millisecond * 10)
+		}
+		server.Close()
+	})
+	return s, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dialWSS(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// readWSS 读取下一条消息，超时返回 false
+func readWSS(t *testing.T, conn *websocket.Conn, timeout time.Duration) (gjson.Result, bool) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	_, buf, err := conn.ReadMessage()
+	if err != nil {
+		return gjson.Result{}, false
+	}
+	return gjson.ParseBytes(buf), true
+}
+
+func callWSS(t *testing.T, conn *websocket.Conn, echo string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"action":"get_login_info","echo":"`+echo+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWSSApiRole(t *testing.T) {
+	s, url := newTestWSS(t)
+	conn := dialWSS(t, url+"/api")
+
+	callWSS(t, conn, "api")
+	ret, ok := readWSS(t, conn, time.Second*3)
+	if !ok || ret.Get("echo").String() != "api" || ret.Get("data.user_id").Int() != testBot {
+		t.Fatalf("api response: %v", ret.Raw)
+	}
+	// API 连接没有握手事件，也收不到上报
+	s.broadcast([]byte(`{"post_type":"message"}`))
+	if ret, ok := readWSS(t, conn, time.Millisecond*300); ok {
+		t.Errorf("api connection received %v", ret.Raw)
+	}
+}
+
+func TestWSSEventRole(t *testing.T) {
+	s, url := newTestWSS(t)
+	conn := dialWSS(t, url+"/event")
+
+	ret, ok := readWSS(t, conn, time.Second*3)
+	if !ok || ret.Get("meta_event_type").String() != "lifecycle" {
+		t.Fatalf("handshake: %v", ret.Raw)
+	}
+	// Event 连接上的API调用被忽略，下一条消息是上报的事件
+	callWSS(t, conn, "event")
+	s.broadcast([]byte(`{"post_type":"message"}`))
+	ret, ok = readWSS(t, conn, time.Second*3)
+	if !ok || ret.Get("post_type").String() != "message" {
+		t.Fatalf("event: %v", ret.Raw)
+	}
+	if ret, ok := readWSS(t, conn, time.Millisecond*300); ok {
+		t.Errorf("event connection received %v", ret.Raw)
+	}
+}
+
+func TestWSSUniversalRole(t *testing.T) {
+	s, url := newTestWSS(t)
+	conn := dialWSS(t, url+"/")
+
+	ret, ok := readWSS(t, conn, time.Second*3)
+	if !ok || ret.Get("meta_event_type").String() != "lifecycle" {
+		t.Fatalf("handshake: %v", ret.Raw)
+	}
+	callWSS(t, conn, "universal")
+	ret, ok = readWSS(t, conn, time.Second*3)
+	if !ok || ret.Get("echo").String() != "universal" {
+		t.Fatalf("api response: %v", ret.Raw)
+	}
+	s.broadcast([]byte(`{"post_type":"message"}`))
+	ret, ok = readWSS(t, conn, time.Second*3)
+	if !ok || ret.Get("post_type").String() != "message" {
+		t.Fatalf("event: %v", ret.Raw)
+	}
+}
+
+func TestWSSUnknownPath(t *testing.T) {
+	_, url := newTestWSS(t)
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/other", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("dial /other: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,36 @@
+package onebot
+
+import "fmt"
+
+// ApiError API调用失败的类型，Retcode 与 Message 固定不变，客户端据此区分失败原因
+// 具体原因用英文写在 wording 中，仅供阅读，不要用来判断
+type ApiError struct {
+	Retcode int64
+	Message string
+}
+
+var (
+	// ErrBadRequest 缺少参数或参数无效
+	ErrBadRequest = ApiError{1400, "BAD_REQUEST"}
+	// ErrMessageBlocked 消息发送后没有拿到回执，一般是被风控拦截
+	ErrMessageBlocked = ApiError{1403, "MESSAGE_BLOCKED"}
+	// ErrUnsupported action 不存在，或先驱不支持、暂未实现
+	ErrUnsupported = ApiError{1404, "UNSUPPORTED_ACTION"}
+	// ErrNotFound 消息、群、成员等查询对象不存在
+	ErrNotFound = ApiError{1410, "NOT_FOUND"}
+	// ErrRateLimited 排队的调用过多
+	ErrRateLimited = ApiError{1429, "RATE_LIMITED"}
+	// ErrBackendFailure 调用先驱失败或返回的内容无法解析
+	ErrBackendFailure = ApiError{1500, "BACKEND_FAILURE"}
+)
+
+func makeError(e ApiError, format string, a ...interface{}) Result {
+	return Result{
+		Status:  "failed",
+		Retcode: e.Retcode,
+		Data:    nil,
+		Message: e.Message,
+		Wording: fmt.Sprintf(format, a...),
+		Echo:    nil,
+	}
+}
@@ -0,0 +1,212 @@
+package onebot
+
+import (
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// forwardPreviewLines 合并转发卡片上预览的消息条数，与QQ一致
+const forwardPreviewLines = 4
+
+// forwardNode 合并转发中的一条消息
+type forwardNode struct {
+	Name    string
+	Uin     int64
+	Content Message
+}
+
+func (this *Routers) SendGroupForwardMsg(bot *BotYaml, params gjson.Result) Result {
+	var groupID int64 = params.Get("group_id").Int()
+	if groupID == 0 {
+		return makeError(ErrBadRequest, "invalid 'group_id'")
+	}
+	target := msgTarget{
+		BotID:   bot.Bot,
+		Type_:   2,
+		GroupID: groupID,
+	}
+	return bot.sendForward(target, json2Message(params.Get("messages")))
+}
+
+func (this *Routers) SendPrivateForwardMsg(bot *BotYaml, params gjson.Result) Result {
+	var userID int64 = params.Get("user_id").Int()
+	if userID == 0 {
+		return makeError(ErrBadRequest, "invalid 'user_id'")
+	}
+	target := msgTarget{
+		BotID:  bot.Bot,
+		Type_:  1,
+		UserID: userID,
+	}
+	return bot.sendForward(target, json2Message(params.Get("messages")))
+}
+
+// sendForward 发送合并转发，默认逐条发送
+// forward 为 xml 时只发送预览卡片，卡片没有上传的聊天记录，无法点开，预览不了的消息返回不支持
+func (bot *BotYaml) sendForward(target msgTarget, message Message) Result {
+	nodes, ret := bot.forwardNodes(message)
+	if ret.Status == "failed" {
+		return ret
+	}
+	if len(nodes) == 0 {
+		return makeError(ErrBadRequest, "no 'node' in messages")
+	}
+	if Conf.Forward != "xml" {
+		return bot.sendForwardMessages(target, nodes)
+	}
+	if !forwardPreviewable(nodes) {
+		return makeError(ErrUnsupported, "forward: xml only previews up to %d text nodes", forwardPreviewLines)
+	}
+	XQ.SendXML(
+		target.BotID,
+		1,
+		target.Type_,
+		target.GroupID,
+		target.UserID,
+		forwardXML(nodes, target.Type_ == 2),
+		0,
+	)
+	// 先驱发送XML没有返回值，没有 message_id
+	return makeOk(map[string]interface{}{"message_id": nil})
+}
+
+// forwardPreviewable 卡片只能预览前几条纯文本，超过条数、含有图片或嵌套等时不能用卡片
+func forwardPreviewable(nodes []forwardNode) bool {
+	if len(nodes) > forwardPreviewLines {
+		return false
+	}
+	for _, node := range nodes {
+		for _, s := range node.Content {
+			if s.Type != "text" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sendForwardMessages 逐条发送，不是自己发的消息前加上发送者名字，嵌套的合并转发依次展开
+// 返回第一条消息的 message_id
+func (bot *BotYaml) sendForwardMessages(target msgTarget, nodes []forwardNode) Result {
+	var first Result
+	for _, node := range nodes {
+		content := Message{}
+		if node.Uin != bot.Bot {
+			content = content.appendText(node.Name + ":\n")
+		}
+		var nested Message
+		for _, s := range node.Content {
+			if s.Type == "node" {
+				nested = append(nested, s)
+			} else {
+				content = append(content, s)
+			}
+		}
+		ret := bot.sendMessage(target, content)
+		if first.Status == "" {
+			first = ret
+		}
+		if ret.Status == "failed" {
+			return ret
+		}
+		if len(nested) != 0 {
+			if ret := bot.sendForward(target, nested); ret.Status == "failed" {
+				return ret
+			}
+		}
+		time.Sleep(time.Millisecond * 200)
+	}
+	return first
+}
+
+// forwardNodes 解析 node 消息段，id 引用数据库中的消息，否则使用自定义的 name uin content
+// 没有 uin 时为机器人自己，没有 name 时为QQ昵称
+func (bot *BotYaml) forwardNodes(message Message) ([]forwardNode, Result) {
+	var nodes []forwardNode
+	for _, s := range message {
+		if s.Type != "node" {
+			continue
+		}
+		var node forwardNode
+		if id := s.Data["id"]; id != "" {
+			msg := bot.findMsg(Str2Int(id))
+			if msg.ID == 0 {
+				return nil, makeError(ErrNotFound, "message %v not found", id)
+			}
+			node.Uin = msg.SenderID
+			node.Content = bot.xqReply2cq(xqCode2Message(msg.Message), msg.GroupID, msg.UserID)
+		} else {
+			node.Name = firstOf(s.Data["name"], s.Data["nickname"])
+			node.Uin = Str2Int(firstOf(s.Data["uin"], s.Data["user_id"]))
+			node.Content = nodeContent(s.Data["content"])
+		}
+		if node.Uin == 0 {
+			node.Uin = bot.Bot
+		}
+		if node.Name == "" {
+			node.Name = XQ.GetNick(bot.Bot, node.Uin)
+		}
+		if node.Name == "" {
+			node.Name = Int2Str(node.Uin)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, makeOk(nil)
+}
+
+// nodeContent content 可以是 CQ码 字符串，也可以是消息段数组，数组在消息段中以原始 JSON 保存
+func nodeContent(content string) Message {
+	if r := gjson.Parse(content); gjson.Valid(content) && (r.IsArray() || r.IsObject()) {
+		return json2Message(r)
+	}
+	return cqCode2Message(content)
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// forwardXML serviceID 35 的聊天记录卡片，预览前几条消息
+// m_resid 是上传聊天记录后得到的编号，先驱无法上传，留空的卡片只能预览不能点开
+func forwardXML(nodes []forwardNode, isGroup bool) string {
+	title := "聊天记录"
+	if isGroup {
+		title = "群聊的聊天记录"
+	}
+	preview := ""
+	for i, node := range nodes {
+		if i == forwardPreviewLines {
+			break
+		}
+		preview += fmt.Sprintf(
+			`<title size="26" color="#777777" maxLines="2" lineSpace="12">%s: %s</title>`,
+			html.EscapeString(node.Name),
+			html.EscapeString(node.Content.brief()),
+		)
+	}
+	return fmt.Sprintf(
+		`<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>`+
+			`<msg serviceID="35" templateID="1" action="viewMultiMsg" brief="[聊天记录]" m_resid="" m_fileName="%d" tSum="%d" sourceMsgId="0" url="" flag="3" adverSign="0" multiMsgFlag="0">`+
+			`<item layout="1" advertiser_id="0" aid="0">`+
+			`<title size="34" maxLines="2" lineSpace="12">%s</title>`+
+			`%s`+
+			`<hr hidden="false" style="0" />`+
+			`<summary size="26" color="#777777">查看%d条转发消息</summary>`+
+			`</item>`+
+			`<source name="聊天记录" icon="" action="" appid="-1" />`+
+			`</msg>`,
+		time.Now().UnixNano(),
+		len(nodes),
+		title,
+		preview,
+		len(nodes),
+	)
+}
@@ -0,0 +1,51 @@
+package onebot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestSendForward(t *testing.T) {
+	backend := testBackend()
+	bot := testBotYaml(testBot)
+	useBackend(t, backend)
+	conf := useConf(t, bot)
+	openTestDB(t, bot)
+	node := `{"type":"node","data":{"name":"a","uin":"20001","content":"hi"}}`
+	five := `[` + strings.Repeat(node+",", 4) + node + `]`
+
+	ret := callApi(t, "send_group_forward_msg", `{"group_id":30001,"messages":`+five+`}`)
+	if ret.Get("status").String() != "ok" || ret.Get("data.message_id").Int() == 0 {
+		t.Fatalf("messages: %v", ret.Raw)
+	}
+	if n := len(backend.CallsOf("SendMsgEX_V2")); n != 5 {
+		t.Errorf("SendMsgEX_V2 called %d times", n)
+	}
+
+	conf.Forward = "xml"
+	ret = callApi(t, "send_group_forward_msg", `{"group_id":30001,"messages":[`+node+`,`+node+`]}`)
+	if ret.Get("status").String() != "ok" || !ret.Get("data").IsObject() || ret.Get("data.message_id").Type != gjson.Null {
+		t.Errorf("xml: %v", ret.Raw)
+	}
+	if n := len(backend.CallsOf("SendXML")); n != 1 {
+		t.Errorf("SendXML called %d times", n)
+	}
+
+	// 预览不了的不发送
+	nested := `{"type":"node","data":{"name":"a","content":[` + node + `]}}`
+	image := `{"type":"node","data":{"name":"a","content":"[CQ:image,file=1.jpg]"}}`
+	for _, messages := range []string{five, `[` + nested + `]`, `[` + image + `]`} {
+		ret = callApi(t, "send_group_forward_msg", `{"group_id":30001,"messages":`+messages+`}`)
+		if ret.Get("retcode").Int() != ErrUnsupported.Retcode {
+			t.Errorf("xml %v: %v", messages, ret.Raw)
+		}
+	}
+	if n := len(backend.CallsOf("SendXML")); n != 1 {
+		t.Errorf("SendXML called %d times", n)
+	}
+	if n := len(backend.CallsOf("SendMsgEX_V2")); n != 5 {
+		t.Errorf("SendMsgEX_V2 called %d times", n)
+	}
+}
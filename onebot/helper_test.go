@@ -0,0 +1,118 @@
+package onebot
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	testBot   int64 = 10001
+	testGroup int64 = 30001
+	testOwner int64 = 20001
+	testUser  int64 = 20002
+)
+
+// testBackend 内存假框架：一个机器人，一个好友，一个群
+func testBackend() *MemoryBackend {
+	backend := NewMemoryBackend()
+	backend.Log = nil
+	backend.Bots = []int64{testBot}
+	backend.Users[testBot] = &MemoryUser{Nickname: "夜夜"}
+	backend.Users[testOwner] = &MemoryUser{Nickname: "主人"}
+	backend.Users[testUser] = &MemoryUser{Nickname: "路人"}
+	backend.Friends = []int64{testOwner}
+	backend.Groups[testGroup] = &MemoryGroup{
+		Name:    "一群",
+		Owner:   testOwner,
+		Members: map[int64]*MemoryMember{testBot: {}, testOwner: {}, testUser: {Card: "路过"}},
+	}
+	return backend
+}
+
+// useBackend 测试期间把 XQ 换成 backend
+func useBackend(t *testing.T, backend Backend) {
+	xq := XQ
+	XQ = backend
+	t.Cleanup(func() { XQ = xq })
+}
+
+// useConf 测试期间把 Conf 换成只包含 bots 的配置
+func useConf(t *testing.T, bots ...*BotYaml) *Yaml {
+	conf := Conf
+	Conf = &Yaml{Cache: &CacheYaml{}, Forward: "messages", BotConfs: bots}
+	t.Cleanup(func() { Conf = conf })
+	return Conf
+}
+
+// useActions 测试期间在一份 apiMap 的拷贝上注册额外的 action，不影响其他测试
+func useActions(t *testing.T, actions ...Action) {
+	saved := apiMap
+	registry := ApiMap{}
+	for _, a := range saved.List() {
+		registry.Register(*a)
+	}
+	registry.Register(actions...)
+	apiMap = registry
+	t.Cleanup(func() { apiMap = saved })
+}
+
+// testBotYaml 只带缓存的机器人配置
+func testBotYaml(id int64) *BotYaml {
+	bot := &BotYaml{Bot: id}
+	bot.Cache = newBotCache(bot)
+	return bot
+}
+
+// openTestDB 为机器人打开临时数据库，测试结束时等缓存写完再关闭
+// 需要在 useBackend/useConf 之后调用，保证先于全局变量还原
+func openTestDB(t *testing.T, bot *BotYaml) {
+	if err := bot.dbOpen(t.TempDir() + "/XQ.db"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		bot.Cache.wait()
+		bot.DB.Close()
+	})
+}
+
+// captureEvents 给机器人加一个正向WS，上报的事件写入返回的 chan
+func captureEvents(bot *BotYaml) chan gjson.Result {
+	events := make(chan gjson.Result, 16)
+	wss := &WSSYaml{
+		Name:              "test",
+		Enable:            true,
+		Host:              "127.0.0.1",
+		PostMessageFormat: "array",
+		BotID:             bot.Bot,
+		Status:            1,
+	}
+	wss.Outbox = NewOutbox("test", "drop_oldest", func(send []byte) {
+		events <- gjson.ParseBytes(send)
+	})
+	bot.WSSConf = append(bot.WSSConf, wss)
+	return events
+}
+
+// callApi 调用 API 并把返回值转换为 JSON 方便断言
+func callApi(t *testing.T, action string, params string) gjson.Result {
+	t.Helper()
+	b, err := json.Marshal(apiMap.CallApi(action, testBot, gjson.Parse(params)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gjson.ParseBytes(b)
+}
+
+func nextEvent(t *testing.T, events chan gjson.Result) gjson.Result {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second * 3):
+		t.Fatal("no event")
+	}
+	return gjson.Result{}
+}
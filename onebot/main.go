@@ -16,23 +16,28 @@ func Main() {
 
 func onStart() {
 	if FirstStart {
-		CreatePath(AppPath)
-		CreatePath(ImagePath)
-		CreatePath(RecordPath)
-		CreatePath(VideoPath)
-
-		INFO("夜夜は世界一かわいい")
-		Conf = Load(AppPath + "config.yml")
-		if Conf == nil {
-			ERROR("晚安~")
-			return
-		}
-		go Conf.runDB()
-		go Conf.runOnebot()
-		apiMap.Register(&apiMap.this)
+		Start(AppPath + "config.yml")
 	}
 	FirstStart = false
 }
 
+// Start 加载配置文件并启动数据库与所有OneBot服务
+func Start(p string) bool {
+	CreatePath(AppPath)
+	CreatePath(ImagePath)
+	CreatePath(RecordPath)
+	CreatePath(VideoPath)
+
+	INFO("夜夜は世界一かわいい")
+	Conf = Load(p)
+	if Conf == nil {
+		ERROR("晚安~")
+		return false
+	}
+	Conf.runDB()
+	go Conf.runOnebot()
+	return true
+}
+
 func onDisable() {
 }
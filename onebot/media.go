@@ -11,8 +11,6 @@ import (
 	"net/http"
 	"os"
 	"strings"
-
-	"github.com/Yiwen-Chan/go-silk/silk"
 )
 
 type GroupHonorInfo struct {
@@ -151,29 +149,6 @@ func Url2Record(url string) string {
 	return path
 }
 
-func rec2silk(path string) string {
-	silkEncoder := &silk.Encoder{}
-	err := silkEncoder.Init("OneBot/record", "OneBot/codec")
-	if err != nil {
-		ERROR("[CQ码解析] %s", err)
-	}
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		ERROR("[CQ码解析] %s", err)
-	}
-	name := "not found"
-	if strings.LastIndex(path, "\\") > strings.LastIndex(path, "/") {
-		name = path[strings.LastIndex(path, "\\")+1 : strings.LastIndex(path, ".")]
-	} else {
-		name = path[strings.LastIndex(path, "/")+1 : strings.LastIndex(path, ".")]
-	}
-	_, err = silkEncoder.EncodeToSilk(data, name, true)
-	if err != nil {
-		ERROR("[CQ码解析] %s", err)
-	}
-	return RecordPath + name + ".silk"
-}
-
 func byte2md5(data []byte) string {
 	m := md5.New()
 	m.Write(data)
@@ -0,0 +1,189 @@
+package onebot
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Segment 消息段，参数与 CQ 码一样都是字符串，数字、对象等参数保存原始 JSON
+type Segment struct {
+	Type string
+	Data map[string]string
+}
+
+// Message 消息，上报与发送共用，JSON 为数组格式，CQCode 为字符串格式
+type Message []Segment
+
+func textSegment(text string) Segment {
+	return Segment{Type: "text", Data: map[string]string{"text": text}}
+}
+
+func (s Segment) MarshalJSON() ([]byte, error) {
+	data := s.Data
+	if data == nil {
+		data = map[string]string{}
+	}
+	return json.Marshal(map[string]interface{}{
+		"type": s.Type,
+		"data": data,
+	})
+}
+
+func (s *Segment) UnmarshalJSON(b []byte) error {
+	*s = json2Segment(gjson.ParseBytes(b))
+	return nil
+}
+
+// CQCode 文本转义后原样输出，其余消息段生成 CQ 码
+func (s Segment) CQCode() string {
+	if s.Type == "text" {
+		return cqEscape(s.Data["text"])
+	}
+	return cqCode(s.Type, s.Data)
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Segment(m))
+}
+
+// CQCode 字符串格式的消息
+func (m Message) CQCode() string {
+	out := ""
+	for _, s := range m {
+		out += s.CQCode()
+	}
+	return out
+}
+
+// appendText 添加文本，与前一个文本消息段合并
+func (m Message) appendText(text string) Message {
+	if text == "" {
+		return m
+	}
+	// 不修改原来的 Data，它可能还被其他消息引用
+	if len(m) > 0 && m[len(m)-1].Type == "text" {
+		m[len(m)-1] = textSegment(m[len(m)-1].Data["text"] + text)
+		return m
+	}
+	return append(m, textSegment(text))
+}
+
+// hasNode 是否含有合并转发的 node 消息段
+func (m Message) hasNode() bool {
+	for _, s := range m {
+		if s.Type == "node" {
+			return true
+		}
+	}
+	return false
+}
+
+// briefNames 摘要中非文本消息段的显示名称
+var briefNames = map[string]string{
+	"face":   "[表情]",
+	"emoji":  "[表情]",
+	"image":  "[图片]",
+	"record": "[语音]",
+	"video":  "[视频]",
+	"node":   "[聊天记录]",
+}
+
+// brief 消息的文字摘要，用于引用回复与合并转发的预览
+func (m Message) brief() string {
+	out := ""
+	for _, s := range m {
+		switch {
+		case s.Type == "text":
+			out += s.Data["text"]
+		case s.Type == "at":
+			out += "@" + s.Data["qq"]
+		case s.Type == "reply" || s.Type == "bubble":
+		case briefNames[s.Type] != "":
+			out += briefNames[s.Type]
+		default:
+			out += "[" + s.Type + "]"
+		}
+	}
+	return strings.TrimSpace(out)
+}
+
+// json2Message 解析API调用中的 message，可以是 CQ码 字符串、消息段数组或单个消息段
+func json2Message(message gjson.Result) Message {
+	switch {
+	case message.Type == gjson.String:
+		return cqCode2Message(message.Str)
+	case message.IsArray():
+		m := Message{}
+		for _, s := range message.Array() {
+			m = append(m, json2Segment(s))
+		}
+		return m
+	case message.IsObject():
+		return Message{json2Segment(message)}
+	}
+	return Message{}
+}
+
+func json2Segment(segment gjson.Result) Segment {
+	s := Segment{Type: segment.Get("type").Str, Data: map[string]string{}}
+	segment.Get("data").ForEach(func(k, v gjson.Result) bool {
+		switch v.Type {
+		case gjson.Null:
+		case gjson.String:
+			s.Data[k.Str] = v.Str
+		default:
+			s.Data[k.Str] = v.Raw
+		}
+		return true
+	})
+	return s
+}
+
+// segmentType 发送时支持的消息段，新增消息段只需要在这里登记
+// Supported 为 false 的只会回复一条不支持的提示，bubble reply node 不是消息内容，由 SendMsg 处理
+type segmentType struct {
+	Type      string
+	Supported bool
+	cq2xq     func(msgTarget, Segment) string
+}
+
+var segmentTypes = []segmentType{
+	{"text", true, msgTarget.cq2xqText},
+	{"at", true, msgTarget.cq2xqAt},
+	{"face", true, msgTarget.cq2xqFace},
+	{"emoji", true, msgTarget.cq2xqEmoji},
+	{"rps", true, msgTarget.cq2xqRps},
+	{"dice", true, msgTarget.cq2xqDice},
+	{"bubble", true, nil},
+	{"image", true, msgTarget.cq2xqImage},
+	{"record", true, msgTarget.cq2xqRecord},
+	{"video", true, msgTarget.cq2xqVideo},
+	{"xml", true, msgTarget.cq2xqXml},
+	{"json", true, msgTarget.cq2xqJson},
+	{"share", true, msgTarget.cq2xqShare},
+	{"music", true, msgTarget.cq2xqMusic},
+	{"weather", true, msgTarget.cq2xqWeather},
+	{"contact", true, msgTarget.cq2xqContact},
+	{"location", true, msgTarget.cq2xqLocation},
+	{"shake", true, msgTarget.cq2xqShake},
+	{"anonymous", true, msgTarget.cq2xqAnonymous},
+	{"poke", false, msgTarget.cq2xqPoke},
+	{"reply", true, nil},
+	{"forward", false, msgTarget.cq2xqForward},
+	{"node", true, nil},
+}
+
+// cq2xq 消息段转XQ码，不认识的消息段只发送 text 参数
+func (target msgTarget) cq2xq(segment Segment) string {
+	for _, t := range segmentTypes {
+		if t.Type == segment.Type && t.cq2xq != nil {
+			return t.cq2xq(target, segment)
+		}
+	}
+	return target.cq2xqDefault(segment)
+}
@@ -0,0 +1,121 @@
+package onebot
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// XMessage OneBot 的 message_id 与先驱消息的对应关系，收到与发出的消息都在这里记录
+// id 即 OneBot 的 message_id，为 int32，用完 1 到 2147483647 后从 1 开始覆盖最早的记录
+type XMessage struct {
+	ID          int64  `db:"id"`
+	SelfID      int64  `db:"self_id"`
+	MessageType int64  `db:"message_type"`
+	GroupID     int64  `db:"group_id"`
+	UserID      int64  `db:"user_id"`
+	SenderID    int64  `db:"sender_id"`
+	MessageNum  int64  `db:"message_num"`
+	MessageID   int64  `db:"message_id"`
+	Time        int64  `db:"time"`
+	Direction   string `db:"direction"`
+	Message     string `db:"message"`
+}
+
+// XMessage 的 direction
+const (
+	msgReceived = "received"
+	msgSent     = "sent"
+)
+
+// saveMsg 同步写入对应关系，返回 message_id，没有数据库或写入失败时为 0
+// 上一个 message_id 保存在 sqlite_sequence 中，超过 int32 后回到 1，用 INSERT OR REPLACE 覆盖同 id 的旧记录
+func (bot *BotYaml) saveMsg(msg XMessage) int64 {
+	if bot == nil || bot.DB == nil {
+		return 0
+	}
+	err := bot.dbTx(func(tx *sql.Tx) {
+		var seq int64
+		if err := tx.QueryRow("SELECT seq FROM sqlite_sequence WHERE name='XMessage'").Scan(&seq); err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+		msg.ID = seq%math.MaxInt32 + 1
+		_, columns := insertSQL(&msg)
+		txExec(tx,
+			fmt.Sprintf("INSERT OR REPLACE INTO XMessage (id,%s) values (?%s)", strings.Join(columns, ","), strings.Repeat(",?", len(columns))),
+			append([]interface{}{msg.ID}, struct2values(&msg, columns)...)...,
+		)
+		// 显式写入 id 时 sqlite_sequence 只会变大，回到 1 以后需要手动更新
+		txExec(tx, "UPDATE sqlite_sequence SET seq=? WHERE name='XMessage'", msg.ID)
+	})
+	if err != nil {
+		ERROR("[数据库][%v] 消息写入失败: %v", bot.Bot, err)
+		return 0
+	}
+	return msg.ID
+}
+
+// saveReceived 记录收到的消息，私聊的 user_id 与群消息的 user_id 都是发送者
+func (bot *BotYaml) saveReceived(xe XEvent) int64 {
+	return bot.saveMsg(XMessage{
+		SelfID:      xe.SelfID,
+		MessageType: xe.MseeageType,
+		GroupID:     xe.GroupID,
+		UserID:      xe.UserID,
+		SenderID:    xe.UserID,
+		MessageNum:  xe.MessageNum,
+		MessageID:   xe.MessageID,
+		Time:        xe.Time,
+		Direction:   msgReceived,
+		Message:     xe.Message,
+	})
+}
+
+// saveSent 记录 SendMsgEX_V2 发出的消息，发送失败时 ok 为 false
+// 私聊的 user_id 是接收者，撤回时使用
+func (bot *BotYaml) saveSent(target msgTarget, out string, data string) (int64, bool) {
+	ret := gjson.Parse(data)
+	if !ret.Get("sendok").Bool() {
+		return 0, false
+	}
+	return bot.saveMsg(XMessage{
+		SelfID:      target.BotID,
+		MessageType: target.Type_,
+		GroupID:     target.GroupID,
+		UserID:      target.UserID,
+		SenderID:    target.BotID,
+		MessageNum:  ret.Get("msgno").Int(),
+		MessageID:   ret.Get("msgid").Int(),
+		Time:        time.Now().Unix(),
+		Direction:   msgSent,
+		Message:     out,
+	}), true
+}
+
+// findMsg 按 message_id 查询消息，找不到时 ID 为 0
+func (bot *BotYaml) findMsg(id int64) XMessage {
+	var msg XMessage
+	if bot != nil && bot.DB != nil && id != 0 {
+		bot.dbSelect(&msg, "id="+Int2Str(id))
+	}
+	return msg
+}
+
+// findMsgByNum 按消息序号查询消息，序号在每个群、每个私聊中单独计数
+// message_id 会循环使用，同一序号有多条时取最新的
+func (bot *BotYaml) findMsgByNum(groupID int64, userID int64, num int64) XMessage {
+	var msg XMessage
+	if bot == nil || bot.DB == nil || num == 0 {
+		return msg
+	}
+	if groupID != 0 {
+		bot.dbSelect(&msg, fmt.Sprintf("group_id=%d and message_num=%d order by time desc, id desc limit 1", groupID, num))
+	} else {
+		bot.dbSelect(&msg, fmt.Sprintf("group_id=0 and user_id=%d and message_num=%d order by time desc, id desc limit 1", userID, num))
+	}
+	return msg
+}
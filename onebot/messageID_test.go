@@ -0,0 +1,46 @@
+package onebot
+
+import (
+	"math"
+	"testing"
+)
+
+// message_id 用完 int32 以后从 1 开始覆盖最早的记录
+func TestSaveMsgWrap(t *testing.T) {
+	bot := testBotYaml(testBot)
+	useBackend(t, testBackend())
+	useConf(t, bot)
+	openTestDB(t, bot)
+
+	if id := bot.saveMsg(XMessage{GroupID: testGroup, MessageNum: 1, Message: "第一条"}); id != 1 {
+		t.Fatalf("first id = %v", id)
+	}
+	if _, err := bot.DB.Exec("UPDATE sqlite_sequence SET seq=? WHERE name='XMessage'", math.MaxInt32-1); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{math.MaxInt32, 1, 2}
+	for i, w := range want {
+		if id := bot.saveMsg(XMessage{GroupID: testGroup, MessageNum: int64(i + 2), Message: Int2Str(w)}); id != w {
+			t.Fatalf("id = %v, want %v", id, w)
+		}
+	}
+	if msg := bot.findMsg(1); msg.Message != "1" {
+		t.Errorf("findMsg(1) = %+v, want the overwritten message", msg)
+	}
+	if msg := bot.findMsg(math.MaxInt32); msg.Message != Int2Str(math.MaxInt32) {
+		t.Errorf("findMsg(MaxInt32) = %+v", msg)
+	}
+	var n int64
+	bot.DB.QueryRow("SELECT COUNT(*) FROM XMessage").Scan(&n)
+	if n != 3 {
+		t.Errorf("XMessage has %v rows", n)
+	}
+
+	// 旧版本写入过超出 int32 的序列，同样回到范围内
+	if _, err := bot.DB.Exec("UPDATE sqlite_sequence SET seq=? WHERE name='XMessage'", int64(math.MaxInt32)+5); err != nil {
+		t.Fatal(err)
+	}
+	if id := bot.saveMsg(XMessage{GroupID: testGroup, MessageNum: 9}); id != 6 {
+		t.Errorf("id after overflowed sequence = %v", id)
+	}
+}
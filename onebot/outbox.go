@@ -0,0 +1,156 @@
+package onebot
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outboxSize 每个服务待上报事件的上限，超过后按 event_policy 处理
+const outboxSize = 100
+
+// outboxBlockTimeout event_policy 为 block 时队列满后最多等待的时间
+const outboxBlockTimeout = time.Second * 10
+
+// Outbox 每个服务独立的待上报队列，WSCPush 只负责放入，不会因为某个服务卡住而阻塞 XQEvent
+// event_policy 为 block 时队列满后新事件排在 waiting 中等待转发腾出位置，超过 outboxBlockTimeout 仍未进入队列则丢弃
+// drop_oldest 丢弃最早的，drop_newest 丢弃新来的
+type Outbox struct {
+	sync.Mutex
+	Name    string
+	Policy  string
+	Dropped int64
+	list    [][]byte
+	waiting []pending
+	ready   chan struct{}
+}
+
+// pending block 策略下等待进入队列的事件
+type pending struct {
+	send []byte
+	time time.Time
+}
+
+// NewOutbox 创建队列并启动转发协程，out 为该服务原本的推送方式
+func NewOutbox(name string, policy string, out func(send []byte)) *Outbox {
+	o := &Outbox{
+		Name:   name,
+		Policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+	go o.run(out)
+	return o
+}
+
+// Offer 放入一条事件，任何策略下都立即返回，只有该服务的转发协程会被卡住
+func (o *Outbox) Offer(send []byte) {
+	o.Lock()
+	switch {
+	case len(o.list) < outboxSize && len(o.waiting) == 0:
+		o.list = append(o.list, send)
+	case o.Policy == "drop_newest":
+		o.Unlock()
+		o.drop()
+		return
+	case o.Policy == "drop_oldest":
+		o.list = append(o.list[1:], send)
+		o.drop()
+	default:
+		o.expire()
+		o.waiting = append(o.waiting, pending{send: send, time: time.Now()})
+	}
+	o.Unlock()
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+// admit 把等待的事件按顺序移入队列，调用方需持有锁
+func (o *Outbox) admit() {
+	o.expire()
+	for len(o.waiting) > 0 && len(o.list) < outboxSize {
+		o.list = append(o.list, o.waiting[0].send)
+		o.waiting = o.waiting[1:]
+	}
+}
+
+// expire 丢弃等待超过 outboxBlockTimeout 的事件，调用方需持有锁
+func (o *Outbox) expire() {
+	for len(o.waiting) > 0 && time.Since(o.waiting[0].time) > outboxBlockTimeout {
+		o.waiting = o.waiting[1:]
+		o.drop()
+	}
+}
+
+// Len 队列中等待转发的事件数，包括 block 策略下等待进入队列的
+func (o *Outbox) Len() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.list) + len(o.waiting)
+}
+
+// DroppedCount 已经丢弃的事件数
+func (o *Outbox) DroppedCount() int64 {
+	return atomic.LoadInt64(&o.Dropped)
+}
+
+// Stat 队列状态，用于 get_status
+func (o *Outbox) Stat(type_ string, name string) map[string]interface{} {
+	stat := map[string]interface{}{
+		"type":    type_,
+		"name":    name,
+		"policy":  "block",
+		"queued":  0,
+		"dropped": int64(0),
+	}
+	if o == nil {
+		return stat
+	}
+	if o.Policy != "" {
+		stat["policy"] = o.Policy
+	}
+	stat["queued"] = o.Len()
+	stat["dropped"] = o.DroppedCount()
+	return stat
+}
+
+// fail 记录一条重试后仍然上报失败且没有落盘的事件，计入 dropped
+func (o *Outbox) fail() {
+	if o == nil {
+		return
+	}
+	WARN("[推送] %v 上报失败，已丢弃 %v 条事件", o.Name, atomic.AddInt64(&o.Dropped, 1))
+}
+
+func (o *Outbox) drop() {
+	if o == nil {
+		return
+	}
+	if atomic.AddInt64(&o.Dropped, 1)%outboxSize == 1 {
+		WARN("[推送] %v 上报过慢，已丢弃 %v 条事件", o.Name, o.DroppedCount())
+	}
+}
+
+func (o *Outbox) run(out func(send []byte)) {
+	defer func() {
+		if err := recover(); err != nil {
+			ERROR("[推送] %v Error: %v", o.Name, err)
+			o.run(out)
+		}
+	}()
+	for range o.ready {
+		for {
+			o.Lock()
+			if len(o.list) == 0 {
+				o.Unlock()
+				break
+			}
+			send := o.list[0]
+			o.list = o.list[1:]
+			o.admit()
+			o.Unlock()
+			out(send)
+		}
+	}
+}
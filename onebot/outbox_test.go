@@ -0,0 +1,115 @@
+package onebot
+
+import (
+	"testing"
+	"time"
+)
+
+// offerStalled 先等转发协程取走第一条并卡住，再放入其余的事件
+func offerStalled(t *testing.T, o *Outbox, n int) {
+	t.Helper()
+	o.Offer([]byte{0})
+	for deadline := time.Now().Add(time.Second); o.Len() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("forwarder did not take the first event")
+		}
+	}
+	for i := 1; i < n; i++ {
+		o.Offer([]byte{byte(i)})
+	}
+}
+
+// block 策略下队列满了 Offer 也立即返回，事件等转发腾出位置后按顺序进入队列
+func TestOutboxBlock(t *testing.T) {
+	gate := make(chan struct{})
+	sent := make(chan byte, outboxSize+2)
+	o := NewOutbox("test", "block", func(send []byte) {
+		<-gate
+		sent <- send[0]
+	})
+	defer close(gate)
+
+	// 转发协程取走第一条后卡住，队列里正好积压 outboxSize 条
+	offerStalled(t, o, outboxSize+1)
+	done := make(chan struct{})
+	go func() {
+		o.Offer([]byte{outboxSize + 1})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Offer blocked while the outbox is full")
+	}
+	if o.DroppedCount() != 0 || o.Len() != outboxSize+1 {
+		t.Fatalf("dropped = %v, queued = %v", o.DroppedCount(), o.Len())
+	}
+
+	for i := 0; i <= outboxSize+1; i++ {
+		gate <- struct{}{}
+		if got := <-sent; got != byte(i) {
+			t.Fatalf("sent %v, want %v", got, i)
+		}
+	}
+	if o.DroppedCount() != 0 {
+		t.Errorf("dropped = %v", o.DroppedCount())
+	}
+}
+
+// 等待超过 outboxBlockTimeout 的事件被丢弃并计入 dropped
+func TestOutboxBlockTimeout(t *testing.T) {
+	useConf(t)
+	gate := make(chan struct{})
+	o := NewOutbox("test", "block", func(send []byte) { <-gate })
+	defer close(gate)
+
+	offerStalled(t, o, outboxSize+2)
+	o.Lock()
+	o.waiting[0].time = time.Now().Add(-outboxBlockTimeout - time.Second)
+	o.Unlock()
+	o.Offer([]byte("last"))
+	if o.DroppedCount() != 1 || o.Len() != outboxSize+1 {
+		t.Errorf("dropped = %v, queued = %v", o.DroppedCount(), o.Len())
+	}
+}
+
+func TestOutboxDropPolicy(t *testing.T) {
+	useConf(t)
+	for _, policy := range []string{"drop_oldest", "drop_newest"} {
+		gate := make(chan struct{})
+		o := NewOutbox("test", policy, func(send []byte) { <-gate })
+		offerStalled(t, o, outboxSize+4)
+		// 第一条被转发协程取走，其余的超出 3 条
+		if o.DroppedCount() != 3 || o.Len() != outboxSize {
+			t.Errorf("%v: dropped = %v, queued = %v", policy, o.DroppedCount(), o.Len())
+		}
+		close(gate)
+	}
+}
+
+// 一个服务卡住不影响 WSCPush 推送给其他服务
+func TestWSCPushStalledEndpoint(t *testing.T) {
+	bot := testBotYaml(testBot)
+	stalled := captureEvents(bot)
+	events := captureEvents(bot)
+	bot.WSSConf[0].Outbox.Policy = "block"
+	useConf(t, bot)
+
+	for i := 0; i <= outboxSize+1; i++ {
+		done := make(chan struct{})
+		e := Event{"post_type": "notice", "time": i}
+		go func() {
+			WSCPush(testBot, e, Conf)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("WSCPush blocked on event %v", i)
+		}
+		nextEvent(t, events)
+	}
+	if n := len(stalled); n != cap(stalled) {
+		t.Errorf("stalled endpoint received %v events", n)
+	}
+}
@@ -0,0 +1,116 @@
+package onebot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// defaultBanDuration 快速操作 ban 未指定 ban_duration 时的禁言时长，单位秒
+const defaultBanDuration = 30 * 60
+
+// HandleQuickOperation 隐藏的 .handle_quick_operation，对事件 context 执行快速操作 operation
+// HTTP 上报的响应也由这里处理，与通信方式无关
+func (this *Routers) HandleQuickOperation(bot *BotYaml, params gjson.Result) Result {
+	return quickOperation(bot.Bot, params.Get("context"), params.Get("operation"))
+}
+
+// quickOperation 按事件类型执行快速操作，返回第一个失败的调用结果
+func quickOperation(bot int64, context gjson.Result, operation gjson.Result) Result {
+	switch context.Get("post_type").Str {
+	case "message":
+		return quickMessage(bot, context, operation)
+	case "request":
+		return quickRequest(bot, context, operation)
+	}
+	return makeOk(nil)
+}
+
+// quickMessage reply 对所有消息生效，delete kick ban 只对群消息生效
+func quickMessage(bot int64, context gjson.Result, operation gjson.Result) Result {
+	isGroup := context.Get("message_type").Str == "group"
+	if reply := operation.Get("reply"); reply.Exists() {
+		message := json2Message(reply)
+		if reply.Type == gjson.String && operation.Get("auto_escape").Bool() {
+			message = Message{textSegment(unicode2chinese(reply.Str))}
+		} else if reply.Type == gjson.String {
+			message = cqCode2Message(unicode2chinese(reply.Str))
+		}
+		// 群消息的 at_sender 默认为 true
+		if isGroup && (!operation.Get("at_sender").Exists() || operation.Get("at_sender").Bool()) {
+			at := Segment{Type: "at", Data: map[string]string{"qq": fmt.Sprint(context.Get("user_id").Int())}}
+			message = append(Message{at}, message...)
+		}
+		if ret := quickCall(bot, "send_msg", map[string]interface{}{
+			"message_type": context.Get("message_type").Str,
+			"group_id":     context.Get("group_id").Int(),
+			"user_id":      context.Get("user_id").Int(),
+			"message":      message,
+		}); ret.Status == "failed" {
+			return ret
+		}
+	}
+	if !isGroup {
+		return makeOk(nil)
+	}
+	if operation.Get("delete").Bool() {
+		if ret := quickCall(bot, "delete_msg", map[string]interface{}{
+			"message_id": context.Get("message_id").Int(),
+		}); ret.Status == "failed" {
+			return ret
+		}
+	}
+	if operation.Get("kick").Bool() {
+		if ret := quickCall(bot, "set_group_kick", map[string]interface{}{
+			"group_id":           context.Get("group_id").Int(),
+			"user_id":            context.Get("user_id").Int(),
+			"reject_add_request": false,
+		}); ret.Status == "failed" {
+			return ret
+		}
+	}
+	if operation.Get("ban").Bool() {
+		duration := int64(defaultBanDuration)
+		if operation.Get("ban_duration").Exists() {
+			duration = operation.Get("ban_duration").Int()
+		}
+		if ret := quickCall(bot, "set_group_ban", map[string]interface{}{
+			"group_id": context.Get("group_id").Int(),
+			"user_id":  context.Get("user_id").Int(),
+			"duration": duration,
+		}); ret.Status == "failed" {
+			return ret
+		}
+	}
+	return makeOk(nil)
+}
+
+// quickRequest approve remark reason 来自 operation，flag 与 sub_type 来自事件
+func quickRequest(bot int64, context gjson.Result, operation gjson.Result) Result {
+	if !operation.Get("approve").Exists() {
+		return makeOk(nil)
+	}
+	switch context.Get("request_type").Str {
+	case "friend":
+		return quickCall(bot, "set_friend_add_request", map[string]interface{}{
+			"flag":    context.Get("flag").String(),
+			"approve": operation.Get("approve").Bool(),
+			"remark":  operation.Get("remark").Str,
+		})
+	case "group":
+		return quickCall(bot, "set_group_add_request", map[string]interface{}{
+			"flag":     context.Get("flag").String(),
+			"sub_type": context.Get("sub_type").Str,
+			"approve":  operation.Get("approve").Bool(),
+			"reason":   operation.Get("reason").Str,
+		})
+	}
+	return makeOk(nil)
+}
+
+// quickCall 以 API 的形式执行一个快速操作
+func quickCall(bot int64, action string, params map[string]interface{}) Result {
+	data, _ := json.Marshal(params)
+	return apiMap.CallApi(action, bot, gjson.ParseBytes(data))
+}
@@ -0,0 +1,123 @@
+package onebot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// newQuickBot 快速操作会发送消息，需要数据库记录 message_id
+func newQuickBot(t *testing.T) *MemoryBackend {
+	backend := testBackend()
+	bot := testBotYaml(testBot)
+	useBackend(t, backend)
+	useConf(t, bot)
+	openTestDB(t, bot)
+	return backend
+}
+
+func TestQuickReplyAtSender(t *testing.T) {
+	backend := newQuickBot(t)
+	group := `{"post_type":"message","message_type":"group","group_id":30001,"user_id":20002,"message_id":1}`
+	private := `{"post_type":"message","message_type":"private","user_id":20001,"message_id":1}`
+	tests := []struct {
+		context   string
+		operation string
+		want      string
+	}{
+		// 群消息默认 at 发送者
+		{group, `{"reply":"hi"}`, "[@20002] hi"},
+		{group, `{"reply":"hi","at_sender":true}`, "[@20002] hi"},
+		{group, `{"reply":"hi","at_sender":false}`, "hi"},
+		// 私聊没有 at
+		{private, `{"reply":"hi"}`, "hi"},
+		{private, `{"reply":"hi","at_sender":true}`, "hi"},
+	}
+	for i, tt := range tests {
+		ret := quickOperation(testBot, gjson.Parse(tt.context), gjson.Parse(tt.operation))
+		if ret.Status != "ok" {
+			t.Fatalf("%v %v: %+v", tt.context, tt.operation, ret)
+		}
+		sends := backend.CallsOf("SendMsgEX_V2")
+		if len(sends) != i+1 {
+			t.Fatalf("SendMsgEX_V2 called %d times", len(sends))
+		}
+		if got := strings.TrimSpace(sends[i].Args["message"].(string)); got != tt.want {
+			t.Errorf("%v %v: message = %q, want %q", tt.context, tt.operation, got, tt.want)
+		}
+	}
+}
+
+func TestQuickBanDuration(t *testing.T) {
+	backend := newQuickBot(t)
+	context := gjson.Parse(`{"post_type":"message","message_type":"group","group_id":30001,"user_id":20002,"message_id":1}`)
+	tests := []struct {
+		operation string
+		want      int64
+	}{
+		{`{"ban":true}`, defaultBanDuration},
+		{`{"ban":true,"ban_duration":60}`, 60},
+		{`{"ban":true,"ban_duration":0}`, 0},
+	}
+	for i, tt := range tests {
+		if ret := quickOperation(testBot, context, gjson.Parse(tt.operation)); ret.Status != "ok" {
+			t.Fatalf("%v: %+v", tt.operation, ret)
+		}
+		calls := backend.CallsOf("ShutUP")
+		if len(calls) != i+1 {
+			t.Fatalf("ShutUP called %d times", len(calls))
+		}
+		if got := calls[i].Args["time"]; got != tt.want {
+			t.Errorf("%v: time = %v, want %v", tt.operation, got, tt.want)
+		}
+	}
+	// 私聊消息不能禁言
+	private := gjson.Parse(`{"post_type":"message","message_type":"private","user_id":20002,"message_id":1}`)
+	quickOperation(testBot, private, gjson.Parse(`{"ban":true}`))
+	if n := len(backend.CallsOf("ShutUP")); n != len(tests) {
+		t.Errorf("ShutUP called %d times for a private message", n)
+	}
+}
+
+// flag 可能是字符串也可能是数字，两种请求都要能同意
+func TestQuickApproveRequest(t *testing.T) {
+	backend := newQuickBot(t)
+	approve := gjson.Parse(`{"approve":true,"remark":"朋友","reason":"欢迎"}`)
+	for _, context := range []string{
+		`{"post_type":"request","request_type":"friend","user_id":20002,"flag":"20002"}`,
+		`{"post_type":"request","request_type":"friend","user_id":20002,"flag":20002}`,
+	} {
+		if ret := quickOperation(testBot, gjson.Parse(context), approve); ret.Status != "ok" {
+			t.Fatalf("%v: %+v", context, ret)
+		}
+	}
+	friends := backend.CallsOf("HandleFriendEvent")
+	if len(friends) != 2 {
+		t.Fatalf("HandleFriendEvent called %d times", len(friends))
+	}
+	for _, call := range friends {
+		if call.Args["user_id"] != testUser || call.Args["approve"] != int64(10) || call.Args["remark"] != "朋友" {
+			t.Errorf("HandleFriendEvent args = %v", call.Args)
+		}
+	}
+
+	for _, context := range []string{
+		`{"post_type":"request","request_type":"group","sub_type":"add","group_id":30001,"user_id":20002,"flag":"213|30001|20002|7"}`,
+		`{"post_type":"request","request_type":"group","sub_type":"invite","group_id":30001,"user_id":20002,"flag":"214|30001|20002|8"}`,
+	} {
+		if ret := quickOperation(testBot, gjson.Parse(context), approve); ret.Status != "ok" {
+			t.Fatalf("%v: %+v", context, ret)
+		}
+	}
+	groups := backend.CallsOf("HandleGroupEvent")
+	if len(groups) != 2 {
+		t.Fatalf("HandleGroupEvent called %d times", len(groups))
+	}
+	for i, call := range groups {
+		if call.Args["sub_type"] != int64(213+i) || call.Args["group_id"] != testGroup || call.Args["user_id"] != testUser ||
+			call.Args["flag"] != int64(7+i) || call.Args["approve"] != int64(10) || call.Args["remark"] != "欢迎" {
+			t.Errorf("HandleGroupEvent args = %v", call.Args)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package onebot
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// replyQuoteLength 回复失败时引用原消息的最大字数
+const replyQuoteLength = 20
+
+// xqReplyJSON SendMsgEX_V2 的附加JSON参数，引用回复 msg
+// 先驱的文档只说明 jsonData 是附加JSON参数，没有给出引用回复的格式，因此只在 reply_json 开启时使用
+func xqReplyJSON(msg XMessage) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"Reply": map[string]interface{}{
+			"MsgNum":  msg.MessageNum,
+			"MsgID":   msg.MessageID,
+			"MsgTime": msg.Time,
+			"SendQQ":  msg.SenderID,
+			"Content": msg.Message,
+		},
+	})
+	return string(data)
+}
+
+// replyQuote 在消息前引用原消息的文字，reply_json 关闭时的引用回复方式
+func replyQuote(msg XMessage) string {
+	quote := []rune(xqCode2Message(msg.Message).brief())
+	if len(quote) > replyQuoteLength {
+		quote = append(quote[:replyQuoteLength], []rune("…")...)
+	}
+	return xqEscape(fmt.Sprintf("「%v: %s」\n", msg.SenderID, string(quote)))
+}
+
+// xqReply2cq 先驱的回复只带消息序号，按序号查询 message_id
+// 查不到时 id 为 0
+func (bot *BotYaml) xqReply2cq(message Message, groupID int64, userID int64) Message {
+	for i, s := range message {
+		if s.Type != "reply" {
+			continue
+		}
+		msg := bot.findMsgByNum(groupID, userID, Str2Int(s.Data["seq"]))
+		message[i] = Segment{Type: "reply", Data: map[string]string{"id": Int2Str(msg.ID)}}
+	}
+	return message
+}
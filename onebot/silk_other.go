@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package onebot
+
+// rec2silk 非Windows平台没有silk编码器，原样返回语音路径
+func rec2silk(path string) string {
+	WARN("[CQ码解析] 当前平台不支持silk编码 %s", path)
+	return path
+}
@@ -0,0 +1,31 @@
+package onebot
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/Yiwen-Chan/go-silk/silk"
+)
+
+func rec2silk(path string) string {
+	silkEncoder := &silk.Encoder{}
+	err := silkEncoder.Init("OneBot/record", "OneBot/codec")
+	if err != nil {
+		ERROR("[CQ码解析] %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		ERROR("[CQ码解析] %s", err)
+	}
+	name := "not found"
+	if strings.LastIndex(path, "\\") > strings.LastIndex(path, "/") {
+		name = path[strings.LastIndex(path, "\\")+1 : strings.LastIndex(path, ".")]
+	} else {
+		name = path[strings.LastIndex(path, "/")+1 : strings.LastIndex(path, ".")]
+	}
+	_, err = silkEncoder.EncodeToSilk(data, name, true)
+	if err != nil {
+		ERROR("[CQ码解析] %s", err)
+	}
+	return RecordPath + name + ".silk"
+}
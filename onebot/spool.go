@@ -0,0 +1,150 @@
+package onebot
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// SpoolYaml 上报队列落盘的配置
+type SpoolYaml struct {
+	Enable     bool   `yaml:"enable"`
+	MaxAge     int64  `yaml:"max_age"`     // 事件最长保留时间，单位秒，0 为不限
+	MaxSize    int64  `yaml:"max_size"`    // 最多保留的事件条数，0 为不限
+	DropPolicy string `yaml:"drop_policy"` // 超过 max_size 时 drop_oldest 丢弃最早的，drop_newest 丢弃新来的
+}
+
+// Spool 落盘的上报队列，连接断开期间的事件在恢复后按顺序补发
+// 每个反向WS或HTTP服务单独一个数据库 OneBot/<bot>/spool/<kind>_<index>.db
+type Spool struct {
+	sync.Mutex
+	Path  string
+	Conf  SpoolYaml
+	db    *sql.DB
+	ready chan struct{}
+}
+
+// OpenSpool 打开或创建上报队列
+func OpenSpool(path string, conf SpoolYaml) (*Spool, error) {
+	CreatePath(path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite 同时只允许一个写入
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS spool ( id INTEGER PRIMARY KEY AUTOINCREMENT, time INTEGER, data BLOB );"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &Spool{
+		Path:  path,
+		Conf:  conf,
+		db:    db,
+		ready: make(chan struct{}, 1),
+	}
+	// 上次退出前没有送达的事件
+	if s.Len() > 0 {
+		s.notify()
+	}
+	return s, nil
+}
+
+// Push 写入一条事件，超过 max_size 时按 drop_policy 丢弃
+func (s *Spool) Push(data []byte) {
+	s.Lock()
+	defer s.Unlock()
+	if s.Conf.MaxSize > 0 && s.count() >= s.Conf.MaxSize {
+		if s.Conf.DropPolicy == "drop_newest" {
+			WARN("[队列] %v 已满，丢弃新事件: %v", s.Path, string(data))
+			return
+		}
+		if _, err := s.db.Exec("DELETE FROM spool WHERE id IN (SELECT id FROM spool ORDER BY id LIMIT ?)", s.count()-s.Conf.MaxSize+1); err != nil {
+			ERROR("[队列] %v Error: %v", s.Path, err)
+		}
+		WARN("[队列] %v 已满，丢弃最早的事件", s.Path)
+	}
+	if _, err := s.db.Exec("INSERT INTO spool (time, data) VALUES (?, ?)", time.Now().Unix(), data); err != nil {
+		ERROR("[队列] %v Error: %v", s.Path, err)
+		return
+	}
+	s.notify()
+}
+
+// Peek 取出最早的一条事件但不删除，送达后需要 Ack
+func (s *Spool) Peek() (int64, []byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.Conf.MaxAge > 0 {
+		res, err := s.db.Exec("DELETE FROM spool WHERE time < ?", time.Now().Unix()-s.Conf.MaxAge)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n > 0 {
+				WARN("[队列] %v 丢弃 %v 条过期事件", s.Path, n)
+			}
+		}
+	}
+	var (
+		id   int64
+		data []byte
+	)
+	err := s.db.QueryRow("SELECT id, data FROM spool ORDER BY id LIMIT 1").Scan(&id, &data)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			ERROR("[队列] %v Error: %v", s.Path, err)
+		}
+		return 0, nil, false
+	}
+	return id, data, true
+}
+
+// Ack 删除已经送达的事件
+func (s *Spool) Ack(id int64) {
+	s.Lock()
+	defer s.Unlock()
+	if _, err := s.db.Exec("DELETE FROM spool WHERE id = ?", id); err != nil {
+		ERROR("[队列] %v Error: %v", s.Path, err)
+	}
+}
+
+// Len 队列中的事件数
+func (s *Spool) Len() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.count()
+}
+
+// Ready 有新事件时可读，队列为 nil 时永远阻塞，可直接用于 select
+func (s *Spool) Ready() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.ready
+}
+
+func (s *Spool) count() int64 {
+	var n int64
+	s.db.QueryRow("SELECT COUNT(*) FROM spool").Scan(&n)
+	return n
+}
+
+func (s *Spool) notify() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// openSpool 按配置打开服务的上报队列，未启用或打开失败时返回 nil
+func openSpool(bot int64, kind string, index int, conf SpoolYaml) *Spool {
+	if !conf.Enable {
+		return nil
+	}
+	path := AppPath + Int2Str(bot) + "/spool/" + kind + "_" + Int2Str(int64(index)) + ".db"
+	s, err := OpenSpool(path, conf)
+	if err != nil {
+		ERROR("[队列][%v] %v Error: %v", bot, path, err)
+		return nil
+	}
+	INFO("[队列][%v] %v 待补发 %v 条", bot, path, s.Len())
+	return s
+}
@@ -0,0 +1,137 @@
+package onebot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func openTestSpool(t *testing.T, path string, conf SpoolYaml) *Spool {
+	t.Helper()
+	s, err := OpenSpool(path, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+// drainSpool 按补发的顺序取出并确认所有事件
+func drainSpool(s *Spool) []string {
+	var sent []string
+	for {
+		id, data, ok := s.Peek()
+		if !ok {
+			return sent
+		}
+		sent = append(sent, string(data))
+		s.Ack(id)
+	}
+}
+
+// 重新打开以后上次没有送达的事件按写入顺序补发
+func TestSpoolReplayOrder(t *testing.T) {
+	useConf(t)
+	path := t.TempDir() + "/wsc_0.db"
+	s := openTestSpool(t, path, SpoolYaml{Enable: true})
+	for i := 1; i <= 3; i++ {
+		s.Push([]byte(fmt.Sprint(i)))
+	}
+	s.db.Close()
+
+	s = openTestSpool(t, path, SpoolYaml{Enable: true})
+	select {
+	case <-s.Ready():
+	default:
+		t.Fatal("reopened spool not ready")
+	}
+	if got := strings.Join(drainSpool(s), ","); got != "1,2,3" {
+		t.Errorf("replay = %v", got)
+	}
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len() = %v after replay", n)
+	}
+}
+
+func TestSpoolDropPolicy(t *testing.T) {
+	useConf(t)
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"drop_oldest", "3,4"},
+		{"drop_newest", "1,2"},
+		{"", "3,4"},
+	}
+	for _, tt := range tests {
+		s := openTestSpool(t, t.TempDir()+"/http_0.db", SpoolYaml{Enable: true, MaxSize: 2, DropPolicy: tt.policy})
+		for i := 1; i <= 4; i++ {
+			s.Push([]byte(fmt.Sprint(i)))
+		}
+		if got := strings.Join(drainSpool(s), ","); got != tt.want {
+			t.Errorf("%q: replay = %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+}
+
+// 反向WS重连后先发握手，再按顺序补发断线期间的事件
+func TestWSCFlushAfterReconnect(t *testing.T) {
+	useConf(t)
+	received := make(chan string, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, buf, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(buf)
+		}
+	}))
+	defer server.Close()
+
+	c := &WSCYaml{
+		Url:                "ws" + strings.TrimPrefix(server.URL, "http"),
+		UseUniversalClient: true,
+		BotID:              testBot,
+		Heart:              make(chan []byte, 1),
+		Queue:              openTestSpool(t, t.TempDir()+"/wsc_0.db", SpoolYaml{Enable: true}),
+	}
+	// 断线期间的事件只写入队列
+	for i := 1; i <= 3; i++ {
+		c.push([]byte(fmt.Sprint(i)))
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(c.Url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c.setConn(conn)
+	c.Heart <- []byte("connect")
+	c.flush()
+
+	var got []string
+	for len(got) < 4 {
+		select {
+		case send := <-received:
+			got = append(got, send)
+		case <-time.After(time.Second * 3):
+			t.Fatalf("received %v", got)
+		}
+	}
+	if strings.Join(got, ",") != "connect,1,2,3" {
+		t.Errorf("received %v", got)
+	}
+	if n := c.Queue.Len(); n != 0 {
+		t.Errorf("Queue.Len() = %v after flush", n)
+	}
+}
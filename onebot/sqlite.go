@@ -4,32 +4,55 @@ import (
 	"database/sql"
 	"fmt"
 	"reflect"
-	"yaya/core"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// runDB 创建各个bot对应的数据库
+// runDB 打开各个bot对应的数据库，需要在启动OneBot服务之前完成
 func (conf *Yaml) runDB() {
-	defer func() {
-		if err := recover(); err != nil {
-			ERROR("[数据库] DB =X=> =X=> Start Error: %v", err)
-		}
-	}()
 	for i, _ := range conf.BotConfs {
-		conf.BotConfs[i].DBPath = AppPath + core.Int2Str(conf.BotConfs[i].Bot) + "/XQ.db"
-		CreatePath(conf.BotConfs[i].DBPath)
-		conf.BotConfs[i].dbCreate(&XEvent{})
+		bot := conf.BotConfs[i]
+		// 数据库打不开时缓存只保存在内存中，仍然需要从先驱获取
+		if err := bot.dbOpen(AppPath + Int2Str(bot.Bot) + "/XQ.db"); err != nil {
+			ERROR("[数据库][%v] DB =X=> =X=> Start Error: %v", bot.Bot, err)
+		}
+		bot.Cache.background(func() {
+			bot.Cache.restore()
+			bot.Cache.refresh()
+		}, "cache.refresh()")
 	}
 }
 
-// dbCreate 根据结构体生成数据库table，tag为"id"为主键，自增
-func (bot *BotYaml) dbCreate(objptr interface{}) {
-	db, err := sql.Open("sqlite3", bot.DBPath)
+// dbOpen 每个bot只打开一次数据库并创建所有的表
+func (bot *BotYaml) dbOpen(path string) error {
+	CreatePath(path)
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	// sqlite 同时只允许一个写入，所有读写共用一个连接排队
+	db.SetMaxOpenConns(1)
+	for _, objptr := range []interface{}{
+		&XMessage{},
+		&XGroupInfo{},
+		&XGroupMember{},
+		&XFriend{},
+		&XMemberStat{},
+		&XMemberDaily{},
+	} {
+		if err := dbCreate(db, objptr); err != nil {
+			db.Close()
+			return err
+		}
+	}
+	bot.DBPath = path
+	bot.DB = db
+	return nil
+}
 
+// dbCreate 根据结构体生成数据库table，tag为"id"为主键，自增
+func dbCreate(db *sql.DB, objptr interface{}) error {
 	table := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (", struct2name(objptr))
 	for i, column := range strcut2columns(objptr) {
 		table += fmt.Sprintf(" %s %s NULL", column, column2type(objptr, column))
@@ -39,56 +62,19 @@ func (bot *BotYaml) dbCreate(objptr interface{}) {
 			table += " );"
 		}
 	}
-	if _, err := db.Exec(table); err != nil {
-		panic(err)
-	}
-	bot.DB = db
+	_, err := db.Exec(table)
+	return err
 }
 
-// dbInsert 根据结构体插入一条数据
+// dbInsert 根据结构体插入一条数据，返回自增的 id，失败时为 0
 func (bot *BotYaml) dbInsert(objptr interface{}) int64 {
 	defer func() {
 		if err := recover(); err != nil {
 			ERROR("[数据库] DB =X=> =X=> Insert Error: %v", err)
 		}
 	}()
-	rows, err := bot.DB.Query("SELECT * FROM " + struct2name(objptr))
-	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
-
-	columns, _ := rows.Columns()
-
-	index := -1
-	names := "("
-	insert := "("
-	for i, column := range columns {
-		if column == "id" {
-			index = i
-			continue
-		}
-		if i+1 != len(columns) {
-			names += column + ","
-			insert += "?,"
-		} else {
-			names += column + ")"
-			insert += "?)"
-		}
-	}
-
-	stmt, err := bot.DB.Prepare("INSERT INTO " + struct2name(objptr) + names + " values " + insert)
-	if err != nil {
-		panic(err)
-	}
-
-	value := []interface{}{}
-	if index == -1 {
-		value = append(value, struct2values(objptr, columns)...)
-	} else {
-		value = append(value, append(struct2values(objptr, columns)[:index], struct2values(objptr, columns)[index+1:]...)...)
-	}
-	res, err := stmt.Exec(value...)
+	query, columns := insertSQL(objptr)
+	res, err := bot.DB.Exec(query, struct2values(objptr, columns)...)
 	if err != nil {
 		panic(err)
 	}
@@ -101,6 +87,22 @@ func (bot *BotYaml) dbInsert(objptr interface{}) int64 {
 	return id
 }
 
+// insertSQL 根据结构体生成插入语句与对应的字段，主键 id 由数据库生成
+func insertSQL(objptr interface{}) (string, []string) {
+	var columns []string
+	for _, column := range strcut2columns(objptr) {
+		if column != "id" {
+			columns = append(columns, column)
+		}
+	}
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s) values (%s)",
+		struct2name(objptr),
+		strings.Join(columns, ","),
+		strings.TrimSuffix(strings.Repeat("?,", len(columns)), ","),
+	), columns
+}
+
 // dbSelect 根据结构体查询对应的表，cmd可为" id = 0 "
 func (bot *BotYaml) dbSelect(objptr interface{}, cmd string) {
 	rows, err := bot.DB.Query(fmt.Sprintf("SELECT * FROM %s where %s", struct2name(objptr), cmd))
@@ -121,6 +123,86 @@ func (bot *BotYaml) dbSelect(objptr interface{}, cmd string) {
 	}
 }
 
+// dbSelectList 根据结构体查询对应的表，所有结果追加到 sliceptr 指向的切片
+func (bot *BotYaml) dbSelectList(sliceptr interface{}, cmd string) {
+	slice := reflect.ValueOf(sliceptr).Elem()
+	objptr := reflect.New(slice.Type().Elem()).Interface()
+	rows, err := bot.DB.Query(fmt.Sprintf("SELECT * FROM %s where %s", struct2name(objptr), cmd))
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		panic(err)
+	}
+	for rows.Next() {
+		obj := reflect.New(slice.Type().Elem())
+		err = rows.Scan(struct2addrs(obj.Interface(), columns)...)
+		if err != nil {
+			panic(err)
+		}
+		slice.Set(reflect.Append(slice, obj.Elem()))
+	}
+}
+
+// dbTx 在一个事务中执行 entry，entry 中 panic 或提交失败时回滚并返回错误
+// 连接只有一个，事务中只能使用 tx，不能再使用 bot.DB
+func (bot *BotYaml) dbTx(entry func(tx *sql.Tx)) (err error) {
+	tx, err := bot.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			tx.Rollback()
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	entry(tx)
+	return tx.Commit()
+}
+
+// txExec 在事务中执行一条SQL语句，返回影响的行数
+func txExec(tx *sql.Tx, cmd string, args ...interface{}) int64 {
+	res, err := tx.Exec(cmd, args...)
+	if err != nil {
+		panic(err)
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
+
+// txInsert 在事务中根据结构体插入一条数据
+func txInsert(tx *sql.Tx, objptr interface{}) {
+	query, columns := insertSQL(objptr)
+	if _, err := tx.Exec(query, struct2values(objptr, columns)...); err != nil {
+		panic(err)
+	}
+}
+
+// txInsertList 在事务中插入切片中的所有结构体，共用一个预编译语句
+func txInsertList(tx *sql.Tx, list interface{}) {
+	slice := reflect.ValueOf(list)
+	if slice.Len() == 0 {
+		return
+	}
+	query, columns := insertSQL(reflect.New(slice.Type().Elem()).Interface())
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		panic(err)
+	}
+	defer stmt.Close()
+	for i := 0; i < slice.Len(); i++ {
+		obj := reflect.New(slice.Type().Elem())
+		obj.Elem().Set(slice.Index(i))
+		if _, err := stmt.Exec(struct2values(obj.Interface(), columns)...); err != nil {
+			panic(err)
+		}
+	}
+}
+
 // strcut2columns 反射得到结构体的 tag 数组
 func strcut2columns(objptr interface{}) []string {
 	var columns []string
@@ -146,10 +228,10 @@ func column2type(objptr interface{}, column string) string {
 		}
 	}
 	if column == "id" {
-		return "INTEGER PRIMARY KEY"
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
 	}
 	switch type_ {
-	case "int64":
+	case "int64", "bool":
 		return "INT"
 	case "string":
 		return "TEXT"
@@ -184,6 +266,8 @@ func struct2values(objptr interface{}, columns []string) []interface{} {
 					values = append(values, elem.Field(i).Int())
 				case "string":
 					values = append(values, elem.Field(i).String())
+				case "bool":
+					values = append(values, elem.Field(i).Bool())
 				default:
 					values = append(values, elem.Field(i).String())
 				}
@@ -7,37 +7,35 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-
-	"yaya/core"
 )
 
 func INFO(s string, v ...interface{}) {
-	core.OutPutLog("[INFO] " + fmt.Sprintf(s, v...))
+	XQ.OutPutLog("[INFO] " + fmt.Sprintf(s, v...))
 }
 
 func WARN(s string, v ...interface{}) {
-	core.OutPutLog("[WARN] " + fmt.Sprintf(s, v...))
+	XQ.OutPutLog("[WARN] " + fmt.Sprintf(s, v...))
 }
 
 func DEBUG(s string, v ...interface{}) {
 	if Conf.Debug {
-		core.OutPutLog("[DEBUG] " + fmt.Sprintf(s, v...))
+		XQ.OutPutLog("[DEBUG] " + fmt.Sprintf(s, v...))
 	}
 }
 
 func ERROR(s string, v ...interface{}) {
-	core.OutPutLog("[ERROR] " + fmt.Sprintf(s, v...))
+	XQ.OutPutLog("[ERROR] " + fmt.Sprintf(s, v...))
 }
 
 func META(s string, v ...interface{}) {
 	if Conf.Meta {
-		core.OutPutLog("[META] " + fmt.Sprintf(s, v...))
+		XQ.OutPutLog("[META] " + fmt.Sprintf(s, v...))
 	}
 }
 
 func TEST(s string, v ...interface{}) {
 	if Conf.Debug {
-		core.OutPutLog("[TEST] " + fmt.Sprintf(s, v...))
+		XQ.OutPutLog("[TEST] " + fmt.Sprintf(s, v...))
 	}
 }
 
@@ -50,17 +48,10 @@ func PathExecute() string {
 }
 
 func CreatePath(path string) {
-	length := len(path)
-	switch {
-	case path[length-1:] != "/":
-		path = path[:strings.LastIndex(path, "/")]
-	case path[length-1:] != "\\":
-		path = path[:strings.LastIndex(path, "\\")]
-	default:
-		//
-	}
-	if !PathExists(path) {
-		err := os.MkdirAll(path, 0644)
+	// 去掉文件名，只保留目录部分
+	path = path[:strings.LastIndexAny(path, "/\\")+1]
+	if path != "" && !PathExists(path) {
+		err := os.MkdirAll(path, 0755)
 		if err != nil {
 			ERROR("生成应用目录失败")
 		}
@@ -118,3 +109,12 @@ func unicode2chinese(text string) string {
 	}
 	return out
 }
+
+func Str2Int(str string) int64 {
+	val, _ := strconv.ParseInt(str, 10, 64)
+	return val
+}
+
+func Int2Str(val int64) string {
+	return strconv.FormatInt(val, 10)
+}
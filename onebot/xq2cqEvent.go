@@ -3,21 +3,12 @@ package onebot
 import (
 	"encoding/json"
 	"fmt"
-
-	"yaya/core"
 )
 
 var AppInfoJson string
 
 type Event map[string]interface{}
 
-func init() {
-	core.Create = XQCreate
-	core.Event = XQEvent
-	core.DestroyPlugin = XQDestroyPlugin
-	core.SetUp = XQSetUp
-}
-
 type XEvent struct {
 	ID          int64  `db:"id"`
 	SelfID      int64  `db:"self_id"`
@@ -63,33 +54,19 @@ func XQEvent(selfID int64, mseeageType int64, subType int64, groupID int64, user
 	// 消息事件
 	// 0：临时会话 1：好友会话 4：群临时会话 7：好友验证会话
 	case 0, 1, 4, 5, 7:
-		for i, _ := range Conf.BotConfs {
-			if selfID == Conf.BotConfs[i].Bot && selfID != 0 {
-				if Conf.BotConfs[i].DB != nil {
-					go Conf.BotConfs[i].dbInsert(&xe)
-				}
-			}
-		}
+		// message_id 在上报前同步写入
+		xe.ID = Conf.getBotConfig(selfID).saveReceived(xe)
 		go ProtectRun(func() { onPrivateMessage(xe) }, "onPrivateMessage()")
 	// 2：群聊信息
 	case 2, 3:
-		for i, _ := range Conf.BotConfs {
-			if selfID == Conf.BotConfs[i].Bot && selfID != 0 {
-				if Conf.BotConfs[i].DB != nil {
-					go Conf.BotConfs[i].dbInsert(&xe)
-				}
-			}
+		xe.ID = Conf.getBotConfig(selfID).saveReceived(xe)
+		if mseeageType == 2 {
+			cache := Conf.getBotConfig(selfID).cache()
+			cache.background(func() { cache.recordActivity(xe) }, "recordActivity()")
 		}
 		go ProtectRun(func() { onGroupMessage(xe) }, "onGroupMessage()")
-	// 10：回音信息
+	// 10：回音信息，发出的消息在发送成功时已经记录
 	case 10:
-		for i, _ := range Conf.BotConfs {
-			if selfID == Conf.BotConfs[i].Bot && selfID != 0 {
-				if Conf.BotConfs[i].DB != nil {
-					go Conf.BotConfs[i].dbInsert(&xe)
-				}
-			}
-		}
 	// 通知事件
 	// 群文件接收
 	case 218:
@@ -119,12 +96,10 @@ func XQEvent(selfID int64, mseeageType int64, subType int64, groupID int64, user
 	// 群消息撤回 subType 2
 	// 好友消息撤回 subType 1
 	case 9:
-		for i, _ := range Conf.BotConfs {
-			if selfID == Conf.BotConfs[i].Bot && selfID != 0 {
-				if Conf.BotConfs[i].DB != nil {
-					Conf.BotConfs[i].dbSelect(&xe, "message_num="+core.Int2Str(xe.MessageNum))
-				}
-			}
+		if xe.SubType == 2 {
+			xe.ID = Conf.getBotConfig(selfID).findMsgByNum(xe.GroupID, 0, xe.MessageNum).ID
+		} else {
+			xe.ID = Conf.getBotConfig(selfID).findMsgByNum(0, xe.NoticeID, xe.MessageNum).ID
 		}
 		if xe.SubType == 2 {
 			go ProtectRun(func() { noticGroupMsgDelete(xe) }, "noticGroupMsgDelete()")
@@ -167,36 +142,35 @@ func WSCPush(bot int64, e Event, c *Yaml) {
 		}
 	}()
 
+	str, array := e.render()
 	for i, _ := range c.BotConfs {
 		if bot == c.BotConfs[i].Bot {
 			for j, _ := range c.BotConfs[i].WSSConf {
-				if c.BotConfs[i].WSSConf[j].Status == 1 && c.BotConfs[i].WSSConf[j].Enable == true && c.BotConfs[i].WSSConf[j].Host != "" {
-					ce := e
+				if c.BotConfs[i].WSSConf[j].online() && c.BotConfs[i].WSSConf[j].Enable == true && c.BotConfs[i].WSSConf[j].Host != "" {
+					send := str
 					if c.BotConfs[i].WSSConf[j].PostMessageFormat == "array" {
-						ce["message"] = cqCode2Array(e["message"].(string))
+						send = array
 					}
-					send, _ := json.Marshal(ce)
-					c.BotConfs[i].WSSConf[j].Event <- send
+					c.BotConfs[i].WSSConf[j].Outbox.Offer(send)
 				}
 			}
 			for k, _ := range c.BotConfs[i].WSCConf {
-				if c.BotConfs[i].WSCConf[k].Status == 1 && c.BotConfs[i].WSCConf[k].Enable == true && c.BotConfs[i].WSCConf[k].Url != "" {
-					ce := e
+				// 启用了落盘队列时断线期间的事件也要保存
+				if (c.BotConfs[i].WSCConf[k].online() || c.BotConfs[i].WSCConf[k].Queue != nil) && c.BotConfs[i].WSCConf[k].Enable == true {
+					send := str
 					if c.BotConfs[i].WSCConf[k].PostMessageFormat == "array" {
-						ce["message"] = cqCode2Array(e["message"].(string))
+						send = array
 					}
-					send, _ := json.Marshal(ce)
-					c.BotConfs[i].WSCConf[k].Event <- send
+					c.BotConfs[i].WSCConf[k].Outbox.Offer(send)
 				}
 			}
 			for l, _ := range c.BotConfs[i].HTTPConf {
-				ce := e
-				if c.BotConfs[i].HTTPConf[l].Status == 1 && c.BotConfs[i].HTTPConf[l].Enable == true && c.BotConfs[i].HTTPConf[l].Host != "" {
+				if (c.BotConfs[i].HTTPConf[l].Status == 1 || c.BotConfs[i].HTTPConf[l].Queue != nil) && c.BotConfs[i].HTTPConf[l].Enable == true && c.BotConfs[i].HTTPConf[l].PostUrl != "" {
+					send := str
 					if c.BotConfs[i].HTTPConf[l].PostMessageFormat == "array" {
-						ce["message"] = cqCode2Array(e["message"].(string))
+						send = array
 					}
-					send, _ := json.Marshal(ce)
-					c.BotConfs[i].HTTPConf[l].Event <- send
+					c.BotConfs[i].HTTPConf[l].Outbox.Offer(send)
 				}
 			}
 		}
@@ -204,15 +178,24 @@ func WSCPush(bot int64, e Event, c *Yaml) {
 
 }
 
-func xq2cqMsgID(xqid int64, xqnum int64) int64 {
-	return core.Str2Int(fmt.Sprintf("%01d%02d%06d%010d", len(core.Int2Str(xqid)), len(core.Int2Str(xqnum)), xqid, xqnum))
-}
-
-func cq2xqMsgID(cqid int64) (int64, int64) {
-	idLen := core.Str2Int(core.Int2Str(cqid)[0:1])
-	numLen := core.Str2Int(core.Int2Str(cqid)[1:3])
-	return core.Str2Int(core.Int2Str(cqid)[(9 - idLen):9]),
-		core.Str2Int(core.Int2Str(cqid)[(19 - numLen):19])
+// render 每个事件只序列化一次 string 与 array 两种格式，各服务按配置取用，不修改原事件
+// raw_message 始终为 CQ码 字符串
+func (e Event) render() ([]byte, []byte) {
+	message, ok := e["message"].(Message)
+	if !ok {
+		send, _ := json.Marshal(e)
+		return send, send
+	}
+	ce := make(Event, len(e)+1)
+	for k, v := range e {
+		ce[k] = v
+	}
+	ce["message"] = message.CQCode()
+	ce["raw_message"] = ce["message"]
+	str, _ := json.Marshal(ce)
+	ce["message"] = message
+	array, _ := json.Marshal(ce)
+	return str, array
 }
 
 func onPrivateMessage(xe XEvent) {
@@ -239,15 +222,9 @@ func onPrivateMessage(xe XEvent) {
 		"sub_type":     Tsubtype,
 		"message_id":   xe.ID,
 		"user_id":      xe.UserID,
-		"message":      xq2cqCode(xe.Message),
-		"raw_message":  xq2cqCode(xe.Message),
+		"message":      Conf.getBotConfig(xe.SelfID).xqReply2cq(xqCode2Message(xe.Message), xe.GroupID, xe.UserID),
 		"font":         0,
-		"sender": Event{
-			"user_id":  xe.UserID,
-			"nickname": "unknown",
-			"sex":      "unknown",
-			"age":      "unknown",
-		},
+		"sender":       Conf.getBotConfig(xe.SelfID).sender(0, xe.UserID),
 	}
 	WSCPush(xe.SelfID, e, Conf)
 }
@@ -272,20 +249,9 @@ func onGroupMessage(xe XEvent) {
 		"group_id":     xe.GroupID,
 		"user_id":      xe.UserID,
 		"anonymous":    nil,
-		"message":      xq2cqCode(xe.Message),
-		"raw_message":  xq2cqCode(xe.Message),
+		"message":      Conf.getBotConfig(xe.SelfID).xqReply2cq(xqCode2Message(xe.Message), xe.GroupID, xe.UserID),
 		"font":         0,
-		"sender": Event{
-			"user_id":  xe.UserID,
-			"nickname": "unknown",
-			"sex":      "unknown",
-			"age":      0,
-			"area":     "",
-			"card":     "",
-			"level":    "",
-			"role":     "admin",
-			"title":    "unknown",
-		},
+		"sender":       Conf.getBotConfig(xe.SelfID).sender(xe.GroupID, xe.UserID),
 	}
 	WSCPush(xe.SelfID, e, Conf)
 }
@@ -322,6 +288,11 @@ func noticeFileUpload(xe XEvent) {
 
 // 管理员变动
 func noticeAdminChange(xe XEvent, typ string) {
+	role := "admin"
+	if typ == "unset" {
+		role = "member"
+	}
+	Conf.getBotConfig(xe.SelfID).cache().setRole(xe.GroupID, xe.UserID, role)
 	e := Event{
 		"time":        xe.Time,
 		"self_id":     xe.SelfID,
@@ -336,6 +307,7 @@ func noticeAdminChange(xe XEvent, typ string) {
 
 // 群成员减少
 func noticeGroupMenberDecrease(xe XEvent, typ string) {
+	Conf.getBotConfig(xe.SelfID).cache().removeMember(xe.GroupID, xe.NoticeID)
 	e := Event{
 		"time":        xe.Time,
 		"self_id":     xe.SelfID,
@@ -351,12 +323,13 @@ func noticeGroupMenberDecrease(xe XEvent, typ string) {
 
 // 群成员增加
 func noticeGroupMenberIncrease(xe XEvent, typ string) {
+	Conf.getBotConfig(xe.SelfID).cache().increaseMember(xe.GroupID, xe.NoticeID)
 	e := Event{
 		"time":        xe.Time,
 		"self_id":     xe.SelfID,
 		"post_type":   "notice",
 		"notice_type": "group_increase",
-		"sub_type":    "unknow",
+		"sub_type":    typ,
 		"group_id":    xe.GroupID,
 		"operator_id": xe.UserID,
 		"user_id":     xe.NoticeID,
@@ -382,6 +355,7 @@ func noticeGroupBan(xe XEvent, typ string) {
 
 // 好友添加
 func noticeFriendAdd(xe XEvent) {
+	Conf.getBotConfig(xe.SelfID).cache().addFriend(xe.UserID)
 	e := Event{
 		"time":        xe.Time,
 		"self_id":     xe.SelfID,
@@ -435,22 +409,30 @@ func requestFriendAdd(xe XEvent) {
 		"request_type": "friend",
 		"user_id":      xe.NoticeID,
 		"comment":      xe.Message,
-		"flag":         xe.UserID,
+		"flag":         fmt.Sprint(xe.UserID),
 	}
 	WSCPush(xe.SelfID, e, Conf)
 }
 
-// 加群请求
+// 加群请求／邀请，flag 为 先驱事件类型|群号|处理对象|seq，处理时原样传回先驱
+// 加群请求的处理对象是申请人，邀请的处理对象是邀请人
 func requestGroupAdd(xe XEvent, typ string) {
+	userID := xe.NoticeID
+	xqType := 213
+	if typ == "invite" {
+		userID = xe.UserID
+		xqType = 214
+	}
 	e := Event{
-		"time":      xe.Time,
-		"self_id":   xe.SelfID,
-		"post_type": "request",
-		"sub_type":  typ,
-		"group_id":  xe.GroupID,
-		"user_id":   xe.NoticeID,
-		"comment":   xe.Message,
-		"flag":      fmt.Sprintf("%v|%v|%v", xe.SubType, xe.GroupID, xe.RawMessage),
+		"time":         xe.Time,
+		"self_id":      xe.SelfID,
+		"post_type":    "request",
+		"request_type": "group",
+		"sub_type":     typ,
+		"group_id":     xe.GroupID,
+		"user_id":      userID,
+		"comment":      xe.Message,
+		"flag":         fmt.Sprintf("%v|%v|%v|%v", xqType, xe.GroupID, userID, xe.RawMessage),
 	}
 	WSCPush(xe.SelfID, e, Conf)
 }
@@ -0,0 +1,61 @@
+package onebot
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestEventRender(t *testing.T) {
+	message := Message{
+		textSegment("a&[b]"),
+		{Type: "at", Data: map[string]string{"qq": "10001"}},
+	}
+	e := Event{"post_type": "message", "message_id": 1, "message": message}
+	str, array := e.render()
+
+	s := gjson.ParseBytes(str)
+	if got := s.Get("message").String(); got != "a&amp;&#91;b&#93;[CQ:at,qq=10001]" {
+		t.Errorf("string message = %q", got)
+	}
+	if got := s.Get("raw_message").String(); got != s.Get("message").String() {
+		t.Errorf("string raw_message = %q", got)
+	}
+
+	a := gjson.ParseBytes(array)
+	if !a.Get("message").IsArray() || a.Get("message.#").Int() != 2 {
+		t.Fatalf("array message = %v", a.Get("message").Raw)
+	}
+	if got := a.Get("message.0.data.text").String(); got != "a&[b]" {
+		t.Errorf("array message[0] = %v", a.Get("message.0").Raw)
+	}
+	if got := a.Get("message.1.type").String() + ":" + a.Get("message.1.data.qq").String(); got != "at:10001" {
+		t.Errorf("array message[1] = %v", a.Get("message.1").Raw)
+	}
+	// 数组格式的 raw_message 仍然是 CQ码 字符串
+	if got := a.Get("raw_message").String(); got != s.Get("raw_message").String() {
+		t.Errorf("array raw_message = %q", got)
+	}
+	if s.Get("message_id").Int() != 1 || a.Get("message_id").Int() != 1 {
+		t.Errorf("message_id = %v %v", s.Get("message_id"), a.Get("message_id"))
+	}
+
+	// 不修改原事件
+	if _, ok := e["raw_message"]; ok {
+		t.Errorf("render added raw_message to the event")
+	}
+	if _, ok := e["message"].(Message); !ok {
+		t.Errorf("render replaced the event message with %T", e["message"])
+	}
+}
+
+func TestEventRenderWithoutMessage(t *testing.T) {
+	e := Event{"post_type": "notice", "notice_type": "group_recall", "message_id": 1}
+	str, array := e.render()
+	if string(str) != string(array) {
+		t.Errorf("string = %s, array = %s", str, array)
+	}
+	if gjson.GetBytes(str, "raw_message").Exists() {
+		t.Errorf("notice has raw_message: %s", str)
+	}
+}
@@ -2,7 +2,6 @@ package onebot
 
 import (
 	"reflect"
-	"yaya/core"
 
 	"github.com/tidwall/gjson"
 )
@@ -32,58 +31,70 @@ type XGroupMember struct {
 	CardChangeable  bool   `db:"card_changeable" json:"card_changeable"`
 }
 
-func (bot *BotYaml) saveGroupInfo() {
-	groupList := core.GetGroupList(bot.Bot)
-	if groupList == "" {
-		return
-	}
+type XFriend struct {
+	UserID   int64  `db:"user_id" json:"user_id"`
+	Nickname string `db:"nickname" json:"nickname"`
+	Remark   string `db:"remark" json:"remark"`
+}
 
-	g := gjson.Parse(groupList)
+// xqGroupList 解析 GetGroupList 的群列表，人数在获取群成员时补上
+func xqGroupList(g gjson.Result) []XGroupInfo {
+	var groups []XGroupInfo
 	for _, o := range append(g.Get("create").Array(), append(g.Get("manage").Array(), g.Get("join").Array()...)...) {
-		memberList := core.GetGroupMemberList_B(bot.Bot, o.Get("gc").Int())
-		m := gjson.Parse(memberList)
-		info := XGroupInfo{
-			GroupID:        o.Get("gc").Int(),
-			GroupName:      unicode2chinese(o.Get("gn").Str),
-			MemberCount:    m.Get("mem_num").Int(),
-			MaxMemberCount: m.Get("max_num").Int(),
-		}
-		bot.dbInsert(info)
+		groups = append(groups, XGroupInfo{
+			GroupID:   o.Get("gc").Int(),
+			GroupName: unicode2chinese(o.Get("gn").Str),
+		})
+	}
+	return groups
+}
 
-		membersMap := m.Get("members").Map()
-		list := reflect.ValueOf(membersMap).MapKeys()
-		for _, member := range list {
+// xqFriendList 解析 GetFriendList 的好友列表，包括所有分组
+func xqFriendList(g gjson.Result) []XFriend {
+	var friends []XFriend
+	g.Get("result").ForEach(func(_, group gjson.Result) bool {
+		for _, o := range group.Get("mems").Array() {
+			friends = append(friends, XFriend{
+				UserID:   o.Get("uin").Int(),
+				Nickname: unicode2chinese(o.Get("name").Str),
+			})
+		}
+		return true
+	})
+	return friends
+}
 
-			qq := member.Interface().(string)
-			nickname := m.Get("members." + qq + ".nk").Str
-			card := m.Get("members." + qq + ".cd").Str
-			role := "member"
-			for _, admin := range m.Get("adm").Array() {
-				if qq == admin.Str {
-					role = "admin"
-				}
-			}
-			if qq == m.Get("owner").Str {
-				role = "owner"
+// xqMemberList 解析 GetGroupMemberList_B 的群成员
+func xqMemberList(groupID int64, m gjson.Result) []XGroupMember {
+	var members []XGroupMember
+	for _, member := range reflect.ValueOf(m.Get("members").Map()).MapKeys() {
+		qq := member.Interface().(string)
+		role := "member"
+		for _, admin := range m.Get("adm").Array() {
+			if qq == admin.Str {
+				role = "admin"
 			}
-			member := XGroupMember{
-				GroupID:         o.Get("gc").Int(),
-				UserID:          core.Str2Int(qq),
-				Nickname:        nickname,
-				Card:            card,
-				Sex:             "unknown",
-				Age:             0,
-				Area:            "",
-				JoinTime:        m.Get("members." + qq + ".jt").Int(),
-				LastSentTime:    m.Get("members." + qq + ".lst").Int(),
-				Level:           m.Get("members." + qq + ".ll").Str,
-				Role:            role,
-				Unfriendly:      false,
-				Title:           "",
-				TitleExpireTime: 0,
-				CardChangeable:  false,
-			}
-			bot.dbInsert(member)
 		}
+		if qq == m.Get("owner").Str {
+			role = "owner"
+		}
+		members = append(members, XGroupMember{
+			GroupID:         groupID,
+			UserID:          Str2Int(qq),
+			Nickname:        unicode2chinese(m.Get("members." + qq + ".nk").Str),
+			Card:            unicode2chinese(m.Get("members." + qq + ".cd").Str),
+			Sex:             "unknown",
+			Age:             0,
+			Area:            "",
+			JoinTime:        m.Get("members." + qq + ".jt").Int(),
+			LastSentTime:    m.Get("members." + qq + ".lst").Int(),
+			Level:           m.Get("members." + qq + ".ll").Str,
+			Role:            role,
+			Unfriendly:      false,
+			Title:           "",
+			TitleExpireTime: 0,
+			CardChangeable:  false,
+		})
 	}
+	return members
 }
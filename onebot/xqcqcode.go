@@ -5,165 +5,173 @@ import (
 	"encoding/hex"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 )
 
-// cqCode2Array 字符串CQ码转数组
-func cqCode2Array(message string) []map[string]interface{} {
-	array := []map[string]interface{}{}
-	for _, elem := range cqCode2Elems(message) {
-		array = append(array, map[string]interface{}{
-			"type": whatCQcode(elem)["type"],
-			"data": whatCQprams(elem)["data"],
-		})
-	}
-	return array
+var (
+	// cqTextEscaper 文本中的 & [ ] 需要转义
+	cqTextEscaper = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;")
+	// cqValueEscaper CQ 码参数中的 , 也需要转义
+	cqValueEscaper = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;", ",", "&#44;")
+	// cqUnescaper 一次替换完成，&amp;#91; 会还原成 &#91; 而不是 [
+	cqUnescaper = strings.NewReplacer("&amp;", "&", "&#91;", "[", "&#93;", "]", "&#44;", ",")
+)
+
+// cqEscape 转义文本，使其不会被解析成 CQ 码
+func cqEscape(text string) string {
+	return cqTextEscaper.Replace(text)
 }
 
-// cqCode2Elems CQ 码转字符串数组
-func cqCode2Elems(message string) []string {
-	elems := []string{}
-	start := 0
-	for {
-		if !strings.Contains(message[start:], "[CQ:") {
-			// 如果没有 CQ 码了就直接把剩下的添加进去然后返回
-			elems = append(elems, message[start:])
-			break
-		}
-		index := strings.Index(message[start:], "[CQ:") + start
-		// 保证不是 CQ 码开头导致无文本
-		if start != index {
-			elems = append(elems, message[start:index])
-		}
-		// 找 CQ 码结束位置并把 CQ 码添加进去
-		end := strings.Index(message[start:], "]") + start
-		elems = append(elems, message[index:end+1])
-		start = end + 1
-		if start == len(message) {
-			break
-		}
-	}
-	return elems
+// cqEscapeValue 转义 CQ 码参数
+func cqEscapeValue(value string) string {
+	return cqValueEscaper.Replace(value)
 }
 
-// whatCQcode 自动解析 CQ 码类型
-func whatCQcode(code string) map[string]string {
-	if !strings.Contains(code, "[CQ:") {
-		return map[string]string{"type": "text"}
-	} else {
-		index := strings.Index(code, ",")
-		if index == -1 {
-			return map[string]string{"type": code[4:(len(code) - 1)]}
-		} else {
-			return map[string]string{"type": code[4:index]}
-		}
-	}
+// cqUnescape 还原文本或 CQ 码参数
+func cqUnescape(text string) string {
+	return cqUnescaper.Replace(text)
 }
 
-// whatCQcode 自动解析 CQ 码参数
-func whatCQprams(code string) map[string]interface{} {
-	elems := map[string]string{}
-	start := 0
-	for {
-		if !strings.Contains(code, "[CQ:") {
-			elems["text"] = code
+// cqCode2Message 解析字符串CQ码
+// 没有闭合的 [CQ: 或者类型为空的 CQ 码按文本处理，相邻的文本会合并
+func cqCode2Message(message string) Message {
+	m := Message{}
+	text := ""
+	for len(message) > 0 {
+		start := strings.Index(message, "[CQ:")
+		if start == -1 {
+			text += message
 			break
 		}
-		if !strings.Contains(code[start:], ",") {
-			// 如果没有 , 那就是没有参数
+		end := strings.Index(message[start:], "]")
+		if end == -1 {
+			text += message
 			break
 		}
-		index := strings.Index(code[start:], ",") + start
-		// 找 参数 结束位置并把 参数 添加进去
-		equal := strings.Index(code[start:], "=") + start
-		if !strings.Contains(code[equal:], ",") {
-			// 如果没有 , 参数 遍历完毕
-			elems[code[index+1:equal]] = escape(code[equal+1 : (len(code) - 1)])
-			break
+		end += start
+		type_, data, ok := cqCodeParse(message[start+4 : end])
+		if !ok {
+			// 从下一个字符继续找，里面可能还有正确的 CQ 码
+			text += message[:start+1]
+			message = message[start+1:]
+			continue
+		}
+		m = m.appendText(cqUnescape(text + message[:start]))
+		text = ""
+		// text 码与前后的文本合并为一个消息段
+		if type_ == "text" {
+			m = m.appendText(data["text"])
+		} else {
+			m = append(m, Segment{Type: type_, Data: data})
 		}
-		end := strings.Index(code[equal:], ",") + equal
-		elems[code[index+1:equal]] = escape(code[equal+1 : end])
-		start = end
+		message = message[end+1:]
 	}
-	return map[string]interface{}{"data": elems}
+	return m.appendText(cqUnescape(text))
 }
 
-// xq2cqCode 普通XQ码转CQ码
-func xq2cqCode(message string) string {
-	// 防止注入
-	message = strings.ReplaceAll(message, "[CQ", "[YaYa")
-	// 转艾特
-	message = strings.ReplaceAll(message, "[@", "[CQ:at,qq=")
-	// 转emoji
-	message = strings.ReplaceAll(message, "[emoji", "[CQ:emoji,id=")
-
-	// 转face
-	face := regexp.MustCompile(`\[Face(.*?)\.gif]`)
-	for _, f := range face.FindAllStringSubmatch(message, -1) {
-		oldpic := f[0]
-		newpic := fmt.Sprintf("[CQ:face,id=%s]", f[1])
-		message = strings.ReplaceAll(message, oldpic, newpic)
+// cqCodeParse 解析 [CQ: 与 ] 之间的内容，参数以第一个 = 分隔键和值
+func cqCodeParse(code string) (string, map[string]string, bool) {
+	fields := strings.Split(code, ",")
+	type_ := fields[0]
+	if type_ == "" || strings.ContainsAny(type_, "[=&") {
+		return "", nil, false
 	}
-
-	// 转图片
-	pic := regexp.MustCompile(`\[pic={(.*?)-(.*?)-(.*?)-(.*?)-(.*?)}(\..*?),(.*?)\]`)
-	for _, p := range pic.FindAllStringSubmatch(message, -1) {
-		oldpic := p[0]
-		md5 := strings.ToUpper(fmt.Sprintf("%s%s%s%s%s", p[1], p[2], p[3], p[4], p[5]))
-		newpic := fmt.Sprintf("[CQ:image,file=%s.image,url=http://gchat.qpic.cn/gchatpic_new//--%s/0]", md5, md5)
-		message = strings.ReplaceAll(message, oldpic, newpic)
+	data := map[string]string{}
+	for _, field := range fields[1:] {
+		equal := strings.Index(field, "=")
+		if equal <= 0 {
+			return "", nil, false
+		}
+		data[field[:equal]] = cqUnescape(field[equal+1:])
 	}
+	return type_, data, true
+}
 
-	pic2 := regexp.MustCompile(`\[pic={(.*?)-(.*?)-(.*?)-(.*?)-(.*?)}(\..*?)]`)
-	for _, p := range pic2.FindAllStringSubmatch(message, -1) {
-		oldpic := p[0]
-		md5 := strings.ToUpper(fmt.Sprintf("%s%s%s%s%s", p[1], p[2], p[3], p[4], p[5]))
-		newpic := fmt.Sprintf("[CQ:image,file=%s.image,url=http://gchat.qpic.cn/gchatpic_new//--%s/0]", md5, md5)
-		message = strings.ReplaceAll(message, oldpic, newpic)
+// cqCode 生成一个 CQ 码，参数按名字排序
+func cqCode(type_ string, data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
 	}
-
-	// 转语音
-	voi := regexp.MustCompile(`\[Voi={(.*?)-(.*?)-(.*?)-(.*?)-(.*?)}(\..*?),(.*?)\]`)
-	for _, v := range voi.FindAllStringSubmatch(message, -1) {
-		oldpic := v[0]
-		newpic := fmt.Sprintf("[CQ:record,file=%s%s%s%s%s]", v[1], v[2], v[3], v[4], v[5])
-		message = strings.ReplaceAll(message, oldpic, newpic)
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("[CQ:")
+	b.WriteString(type_)
+	for _, k := range keys {
+		b.WriteString(",")
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(cqEscapeValue(data[k]))
 	}
-
-	return message
+	b.WriteString("]")
+	return b.String()
 }
 
-// cq2xqCode 普通CQ码转XQ码
-func cq2xqCode(message string) string {
-	// 转艾特
-	message = strings.ReplaceAll(message, "[CQ:at,qq=", "[@")
-	// 转emoji
-	message = strings.ReplaceAll(message, "[CQ:emoji,id=", "[emoji")
+// xqCodes 能转换成 CQ 码的 XQ 码，在 [ 处依次尝试匹配
+var xqCodes = []struct {
+	re      *regexp.Regexp
+	convert func(m []string) Segment
+}{
+	// 回复 [Reply=消息序号,...]，message_id 由 xqReply2cq 查询
+	{regexp.MustCompile(`^\[Reply=(\d+)[^\[\]]*\]`), func(m []string) Segment {
+		return Segment{"reply", map[string]string{"seq": m[1]}}
+	}},
+	// 艾特
+	{regexp.MustCompile(`^\[@([^\[\]]+)\]`), func(m []string) Segment {
+		return Segment{"at", map[string]string{"qq": m[1]}}
+	}},
+	// emoji
+	{regexp.MustCompile(`^\[emoji=([0-9A-Fa-f]+)\]`), func(m []string) Segment {
+		return Segment{"emoji", map[string]string{"id": m[1]}}
+	}},
+	// face
+	{regexp.MustCompile(`^\[Face([^\[\]]*?)\.gif\]`), func(m []string) Segment {
+		return Segment{"face", map[string]string{"id": m[1]}}
+	}},
+	// 图片
+	{regexp.MustCompile(`^\[pic=\{([^\[\]{}-]+)-([^\[\]{}-]+)-([^\[\]{}-]+)-([^\[\]{}-]+)-([^\[\]{}-]+)\}\.[^\[\],]*(,[^\[\]]*)?\]`), func(m []string) Segment {
+		md5 := strings.ToUpper(m[1] + m[2] + m[3] + m[4] + m[5])
+		return Segment{"image", map[string]string{
+			"file": md5 + ".image",
+			"url":  fmt.Sprintf("http://gchat.qpic.cn/gchatpic_new//--%s/0", md5),
+		}}
+	}},
+	// 语音
+	{regexp.MustCompile(`^\[Voi=\{([^\[\]{}-]+)-([^\[\]{}-]+)-([^\[\]{}-]+)-([^\[\]{}-]+)-([^\[\]{}-]+)\}\.[^\[\],]*,[^\[\]]*\]`), func(m []string) Segment {
+		return Segment{"record", map[string]string{"file": m[1] + m[2] + m[3] + m[4] + m[5]}}
+	}},
+}
 
-	// 转face
-	face := regexp.MustCompile(`\[CQ:face,id=(.*?)\]`)
-	for _, f := range face.FindAllStringSubmatch(message, -1) {
-		oldpic := f[0]
-		newpic := fmt.Sprintf("[Face%s.gif]", f[1])
-		message = strings.ReplaceAll(message, oldpic, newpic)
+// xqCode2Message 解析XQ码，不认识的XQ码按文本处理，用户发送的 [CQ: 只是文本
+func xqCode2Message(message string) Message {
+	m := Message{}
+	text := 0
+	for i := 0; i < len(message); i++ {
+		if message[i] != '[' {
+			continue
+		}
+		for _, code := range xqCodes {
+			match := code.re.FindStringSubmatch(message[i:])
+			if match == nil {
+				continue
+			}
+			m = m.appendText(message[text:i])
+			m = append(m, code.convert(match))
+			i += len(match[0]) - 1
+			text = i + 1
+			break
+		}
 	}
+	return m.appendText(message[text:])
+}
 
-	// 转图片
-	pic := regexp.MustCompile(`\[CQ:image,file=(.*?)\]`)
-	for _, p := range pic.FindAllStringSubmatch(message, -1) {
-		oldpic := p[0]
-		newpic := fmt.Sprintf("[pic=%s]", p[1])
-		message = strings.ReplaceAll(message, oldpic, newpic)
-	}
+// xqCodeHead 先驱会解析的XQ码开头，与 xqCodes 和 cq2xq 生成的XQ码一致，其余的 [ 原样保留
+var xqCodeHead = regexp.MustCompile(`\[(@|pic=|ShowPic=|Voi=|Face|emoji=|Reply=|Next\])`)
 
-	// 转语音
-	voi := regexp.MustCompile(`\[CQ:record,file=(.*?)\]`)
-	for _, v := range voi.FindAllStringSubmatch(message, -1) {
-		oldpic := v[0]
-		newpic := fmt.Sprintf("[Voi=%s]", v[1])
-		message = strings.ReplaceAll(message, oldpic, newpic)
-	}
-	return message
+// xqEscape 在 [ 后插入零宽空格，文本不会被先驱当成XQ码
+func xqEscape(text string) string {
+	return xqCodeHead.ReplaceAllString(text, "[\u200b$1")
 }
 
 func emoji2xq(text string) string {
@@ -209,11 +217,3 @@ func xq2emoji(text string) string {
 	}
 	return string(ret)
 }
-
-func escape(text string) string {
-	text = strings.ReplaceAll(text, "&amp;", "&")
-	text = strings.ReplaceAll(text, "&#44;", ",")
-	text = strings.ReplaceAll(text, "&#91;", "[")
-	text = strings.ReplaceAll(text, "&#93;", "]")
-	return text
-}
@@ -0,0 +1,161 @@
+package onebot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func arrayJSON(t testing.TB, m Message) string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCQCode2Message(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"空消息", "", `[]`},
+		{"纯文本", "hello", `[{"data":{"text":"hello"},"type":"text"}]`},
+		{"文本中的右括号", "a]b", `[{"data":{"text":"a]b"},"type":"text"}]`},
+		{"文本反转义", "&#91;CQ:at,qq=1&#93; &amp;#91;", `[{"data":{"text":"[CQ:at,qq=1] \u0026#91;"},"type":"text"}]`},
+		{"无参数", "[CQ:shake]", `[{"data":{},"type":"shake"}]`},
+		{"文本与CQ码", "hi[CQ:at,qq=123] there", `[{"data":{"text":"hi"},"type":"text"},{"data":{"qq":"123"},"type":"at"},{"data":{"text":" there"},"type":"text"}]`},
+		{"参数中的等号", "[CQ:share,url=http://a.com/?x=1&amp;y=2]", `[{"data":{"url":"http://a.com/?x=1\u0026y=2"},"type":"share"}]`},
+		{"参数反转义", "[CQ:text,text=a&#44;b&#91;c&#93;]", `[{"data":{"text":"a,b[c]"},"type":"text"}]`},
+		{"未闭合", "a[CQ:at,qq=1", `[{"data":{"text":"a[CQ:at,qq=1"},"type":"text"}]`},
+		{"空类型", "[CQ:]x", `[{"data":{"text":"[CQ:]x"},"type":"text"}]`},
+		{"参数缺少等号", "[CQ:at,qq]", `[{"data":{"text":"[CQ:at,qq]"},"type":"text"}]`},
+		{"文本码与文本合并", "x[CQ:text,text=a]", `[{"data":{"text":"xa"},"type":"text"}]`},
+		{"文本码前后的文本", "x[CQ:text,text=a]y[CQ:text,text=b]", `[{"data":{"text":"xayb"},"type":"text"}]`},
+		{"嵌套", "[CQ:[CQ:face,id=1]", `[{"data":{"text":"[CQ:"},"type":"text"},{"data":{"id":"1"},"type":"face"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := arrayJSON(t, cqCode2Message(tt.message)); got != tt.want {
+				t.Errorf("cqCode2Message(%q) = %s, want %s", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageCQCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"文本转义", `[{"type":"text","data":{"text":"[CQ:at,qq=1] & ,"}}]`, "&#91;CQ:at,qq=1&#93; &amp; ,"},
+		{"参数转义并排序", `[{"type":"share","data":{"url":"http://a.com/?x=1,y=[2]","title":"a&b"}}]`, "[CQ:share,title=a&amp;b,url=http://a.com/?x=1&#44;y=&#91;2&#93;]"},
+		{"非字符串参数", `[{"type":"at","data":{"qq":123}}]`, "[CQ:at,qq=123]"},
+		{"单个消息段", `{"type":"face","data":{"id":"1"}}`, "[CQ:face,id=1]"},
+		{"字符串", `"a[CQ:face,id=1]"`, "a[CQ:face,id=1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := json2Message(gjson.Parse(tt.message)).CQCode(); got != tt.want {
+				t.Errorf("json2Message(%s).CQCode() = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXqCode2Message(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"纯文本", "hello", "hello"},
+		{"注入", "[CQ:at,qq=all]", "&#91;CQ:at,qq=all&#93;"},
+		{"转义文本", "a & b [c]", "a &amp; b &#91;c&#93;"},
+		{"艾特", "[@123] hi", "[CQ:at,qq=123] hi"},
+		{"表情", "[Face14.gif]", "[CQ:face,id=14]"},
+		{"emoji", "[emoji=f09f9881]", "[CQ:emoji,id=f09f9881]"},
+		{"图片", "[pic={1A2B-3C-4D-5E-6F}.jpg]", "[CQ:image,file=1A2B3C4D5E6F.image,url=http://gchat.qpic.cn/gchatpic_new//--1A2B3C4D5E6F/0]"},
+		{"带参数的图片", "x[pic={1a-2b-3c-4d-5e}.png,type=1]", "x[CQ:image,file=1A2B3C4D5E.image,url=http://gchat.qpic.cn/gchatpic_new//--1A2B3C4D5E/0]"},
+		{"语音", "[Voi={1-2-3-4-5}.amr,len=1]", "[CQ:record,file=12345]"},
+		{"回复", "[Reply=12,MsgID=3]hi", "[CQ:reply,seq=12]hi"},
+		{"未知XQ码", "[ShowPic=1]", "&#91;ShowPic=1&#93;"},
+		{"未闭合", "[@123", "&#91;@123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := xqCode2Message(tt.message).CQCode(); got != tt.want {
+				t.Errorf("xqCode2Message(%q).CQCode() = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+// FuzzCQCodeRoundTrip 解析后再编码再解析结果不变，任意文本转义后解析仍是原文
+func FuzzCQCodeRoundTrip(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"hello",
+		"[CQ:at,qq=123]",
+		"a]b[CQ:",
+		"[CQ:share,url=http://a.com/?x=1&amp;y=2,title=&#44;]",
+		"&amp;#91;[CQ:[CQ:face,id=1]",
+		"x[CQ:text,text=a]",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, message string) {
+		m := cqCode2Message(message)
+		encoded := m.CQCode()
+		if got, want := arrayJSON(t, cqCode2Message(encoded)), arrayJSON(t, m); got != want {
+			t.Errorf("round trip %q -> %q: got %s, want %s", message, encoded, got, want)
+		}
+		text := cqCode2Message(cqEscape(message))
+		if message == "" {
+			if len(text) != 0 {
+				t.Errorf("cqEscape(%q) parsed to %v", message, text)
+			}
+			return
+		}
+		if len(text) != 1 || text[0].Type != "text" || text[0].Data["text"] != message {
+			t.Errorf("cqEscape(%q) parsed to %v", message, text)
+		}
+		xq := xqCode2Message(message)
+		if got, want := arrayJSON(t, cqCode2Message(xq.CQCode())), arrayJSON(t, xq); got != want {
+			t.Errorf("xqCode2Message(%q) round trip: got %s, want %s", message, got, want)
+		}
+	})
+}
+
+func TestXqEscape(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"纯文本", "hello [1]", "hello [1]"},
+		{"艾特", "[@123]", "[\u200b@123]"},
+		{"图片", "a[pic=C:\\1.jpg]", "a[\u200bpic=C:\\1.jpg]"},
+		{"表情", "[Face1.gif][Next]", "[\u200bFace1.gif][\u200bNext]"},
+		{"语音", "[Voi={1-2-3-4-5}.amr,1]", "[\u200bVoi={1-2-3-4-5}.amr,1]"},
+		{"秀图", "[ShowPic=C:\\1.jpg,type=1]", "[\u200bShowPic=C:\\1.jpg,type=1]"},
+		{"回复", "[Reply=1,2]", "[\u200bReply=1,2]"},
+		{"emoji", "[emoji=F09F9880]", "[\u200bemoji=F09F9880]"},
+		{"日志", "[INFO] started", "[INFO] started"},
+		{"单个字母", "[a] [b]", "[a] [b]"},
+		{"Markdown 链接", "[OneBot](https://github.com/howmanybots/onebot)", "[OneBot](https://github.com/howmanybots/onebot)"},
+		{"CQ码", "[CQ:at,qq=1]", "[CQ:at,qq=1]"},
+		{"不完整的 Next", "[Nextday]", "[Nextday]"},
+		{"小写", "[face1.gif][pic]", "[face1.gif][pic]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := xqEscape(tt.text); got != tt.want {
+				t.Errorf("xqEscape(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}